@@ -0,0 +1,152 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"net"
+	"sync"
+	"time"
+)
+
+//认证成功后channel属性中保存的应用ID
+const AUTH_ATTRIBUTE_APP_ID = "app_id"
+
+//根据应用ID查找应用的签名密钥
+type AppSecretLoader func(appId string) (string, error)
+
+//应用签名，和集群内模块认证使用相同的签名算法
+func AppSign(secret, appId string, timestamp int64, nonce string) string {
+	return protocol.HmacSign(secret, appId, timestamp, nonce)
+}
+
+//使用当前时间和nonce签名
+func (this *AuthHeader) MakeSign(secret, nonce string) *AuthHeader {
+	this.Timestamp = time.Now().Unix()
+	this.Nonce = nonce
+	this.Sign = AppSign(secret, this.AppId, this.Timestamp, this.Nonce)
+	return this
+}
+
+type authFailure struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+//应用认证，校验AuthHeader的签名、签名时间和nonce，同一个地址连续认证失败后一段时间内拒绝认证
+type AppAuthChecker struct {
+	protocol.ModuleAuthChecker
+	//签名时间误差和nonce重放校验
+	protocol.SignVerifier
+	Secret AppSecretLoader
+
+	//应用的角色，认证成功后保存到channel属性中用于请求授权，可以为空
+	Roles func(appId string) []string
+
+	//连续失败MaxFailures次后，FailureBan时间内拒绝此地址的认证
+	MaxFailures int
+	FailureBan  time.Duration
+
+	lock      sync.Mutex
+	failures  map[string]*authFailure
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewAppAuthChecker(secret AppSecretLoader) *AppAuthChecker {
+	checker := &AppAuthChecker{
+		Secret:      secret,
+		MaxFailures: 5, FailureBan: time.Minute,
+		failures: map[string]*authFailure{},
+		now:      time.Now,
+	}
+	checker.MaxSkew = time.Minute * 5
+	return checker
+}
+
+func (this *AppAuthChecker) Auth(channel remoting.RemotingChannel, command *protocol.TenuredCommand) error {
+	host := hostOf(channel.RemoteAddr())
+	if this.banned(host) {
+		return errors.New(fmt.Sprintf("too many auth failures from %s", host))
+	}
+	header := &AuthHeader{}
+	if err := command.GetHeader(header); err != nil {
+		this.fail(host)
+		return err
+	}
+	if err := this.verify(header); err != nil {
+		this.fail(host)
+		return err
+	}
+	this.lock.Lock()
+	delete(this.failures, host)
+	this.lock.Unlock()
+
+	channel.Attributes()[AUTH_ATTRIBUTE_APP_ID] = header.AppId
+	if this.Roles != nil {
+		channel.Attributes()[protocol.AUTH_ATTRIBUTE_ROLES] = this.Roles(header.AppId)
+	}
+	return this.ModuleAuthChecker.Auth(channel, command)
+}
+
+func (this *AppAuthChecker) verify(header *AuthHeader) error {
+	if header.AppId == "" || header.Sign == "" || header.Nonce == "" {
+		return protocol.ErrorNoAuth()
+	}
+	secret, err := this.Secret(header.AppId)
+	if err != nil {
+		return err
+	}
+	if err := this.Verify(secret, header.AppId, header.Timestamp, header.Nonce, header.Sign, this.now()); err != nil {
+		return errors.New(fmt.Sprintf("app %v", err))
+	}
+	return nil
+}
+
+func (this *AppAuthChecker) banned(host string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.now()
+	this.sweep(now)
+	failure, has := this.failures[host]
+	return has && now.Before(failure.until)
+}
+
+func (this *AppAuthChecker) fail(host string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := this.now()
+	failure, has := this.failures[host]
+	if !has {
+		failure = &authFailure{}
+		this.failures[host] = failure
+	}
+	failure.count++
+	failure.last = now
+	if failure.count >= this.MaxFailures {
+		failure.count = 0
+		failure.until = now.Add(this.FailureBan)
+	}
+}
+
+//清理过期的失败记录
+func (this *AppAuthChecker) sweep(now time.Time) {
+	if now.Sub(this.lastSweep) < this.FailureBan {
+		return
+	}
+	this.lastSweep = now
+	for host, failure := range this.failures {
+		if now.After(failure.until) && now.Sub(failure.last) > this.FailureBan {
+			delete(this.failures, host)
+		}
+	}
+}
+
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
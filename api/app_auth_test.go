@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type authChannel struct {
+	remoting.RemotingChannel
+	addr  string
+	attrs map[string]interface{}
+}
+
+func (this *authChannel) RemoteAddr() string {
+	return this.addr
+}
+
+func (this *authChannel) Attributes() map[string]interface{} {
+	return this.attrs
+}
+
+func authCommand(header *AuthHeader) *protocol.TenuredCommand {
+	command := protocol.NewRequest(protocol.REQUEST_CODE_ATUH)
+	_ = command.SetHeader(header)
+	return command
+}
+
+func TestAppAuthChecker(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+	checker := NewAppAuthChecker(func(appId string) (string, error) {
+		if appId == "1001" {
+			return "secret", nil
+		}
+		return "", errors.New("not found app " + appId)
+	})
+	checker.MaxFailures = 3
+	checker.now = func() time.Time { return now }
+
+	signed := func(secret, nonce string, timestamp time.Time) *AuthHeader {
+		header := &AuthHeader{AppId: "1001", Timestamp: timestamp.Unix(), Nonce: nonce}
+		header.Sign = AppSign(secret, header.AppId, header.Timestamp, header.Nonce)
+		return header
+	}
+	channel := func(addr string) *authChannel {
+		return &authChannel{addr: addr, attrs: map[string]interface{}{}}
+	}
+
+	ok := channel("10.0.0.1:5001")
+	assert.Nil(t, checker.Auth(ok, authCommand(signed("secret", "n1", now))))
+	assert.True(t, checker.IsAuthed(ok))
+	assert.Equal(t, "1001", ok.attrs[AUTH_ATTRIBUTE_APP_ID])
+
+	//签名错误、时间超出误差、nonce重复使用
+	assert.NotNil(t, checker.Auth(channel("10.0.0.2:5001"), authCommand(signed("wrong", "n2", now))))
+	assert.NotNil(t, checker.Auth(channel("10.0.0.2:5002"), authCommand(signed("secret", "n3", now.Add(-time.Minute*6)))))
+	replay := channel("10.0.0.2:5003")
+	assert.NotNil(t, checker.Auth(replay, authCommand(signed("secret", "n1", now))))
+	assert.False(t, checker.IsAuthed(replay))
+
+	//同一个地址连续失败后拒绝认证，其他地址不受影响
+	assert.NotNil(t, checker.Auth(channel("10.0.0.2:5004"), authCommand(signed("secret", "n4", now))))
+	assert.Nil(t, checker.Auth(channel("10.0.0.3:5001"), authCommand(signed("secret", "n5", now))))
+
+	now = now.Add(time.Minute * 2)
+	assert.Nil(t, checker.Auth(channel("10.0.0.2:5005"), authCommand(signed("secret", "n4", now))))
+
+	//nonce过期后清理，可以再次使用
+	now = now.Add(time.Minute * 10)
+	assert.NotNil(t, checker.Auth(channel("10.0.0.4:5001"), authCommand(signed("secret", "n6", now.Add(time.Minute*6)))))
+	assert.Nil(t, checker.Auth(channel("10.0.0.4:5002"), authCommand(signed("secret", "n1", now))))
+}
@@ -24,7 +24,7 @@ func Init() error {
 			return err
 		}
 	}
-	server, _ = NewAccountServiceClient("tenured_store", reg)
+	server, _ = NewAccountServiceClient("tenured_store", reg, nil)
 
 	return server.Start()
 }
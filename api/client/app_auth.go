@@ -0,0 +1,31 @@
+package client
+
+import (
+	"errors"
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
+	"strconv"
+)
+
+//按照ID查询账户，tmake生成的AccountServiceClient实现了此接口
+type AccountGetter interface {
+	Get(id uint64) (*api.Account, *protocol.TenuredError)
+}
+
+//从账户服务中查找应用的签名密钥，应用ID为账户ID，只有审核通过的账户可以认证
+func AccountSecretLoader(accountClient AccountGetter) api.AppSecretLoader {
+	return func(appId string) (string, error) {
+		id, err := strconv.ParseUint(appId, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		account, tenuredErr := accountClient.Get(id)
+		if tenuredErr != nil {
+			return "", tenuredErr
+		}
+		if account == nil || account.Status != api.AccountStatusOK || account.SecretKey == "" {
+			return "", errors.New("app not found or disabled: " + appId)
+		}
+		return account.SecretKey, nil
+	}
+}
@@ -27,33 +27,29 @@ func (this *ClusterIdServiceClient) Shutdown(interrupt bool) {
 	this.serviceManager.Shutdown(interrupt)
 }
 
+//获取ID没有副作用，失败时按照重试策略换其他实例重试
 func (this *ClusterIdServiceClient) Get() (uint64, *protocol.TenuredError) {
-
-	serverInstance, _, err := this.roundLB.Select(api.ClusterIdServiceGet)
-
-	if err != nil || len(serverInstance) == 0 || registry.AllNotOK(serverInstance...) {
-		return 0, protocol.ErrorRouter()
-	}
-
-	var respBody []byte
-	if respBody, err = this.Invoke(serverInstance[0], api.ClusterIdServiceGet, nil, nil, time.Millisecond*3000, nil); !commons.IsNil(err) {
-		return 0, protocol.ConvertError(err)
+	if respBody, err := this.InvokeRetry(this.roundLB, []interface{}{api.ClusterIdServiceGet},
+		api.ClusterIdServiceGet, nil, nil, time.Millisecond*3000, nil); err != nil {
+		return 0, err
 	} else {
 		return commons.ToUInt64(respBody), nil
 	}
-
 }
 
-func NewClusterIdServiceClient(serverName string, reg registry.ServiceRegistry) (*ClusterIdServiceClient, error) {
+//config为空时使用默认的客户端配置
+func NewClusterIdServiceClient(serverName string, reg registry.ServiceRegistry, config *protocol.ClientConfig) (*ClusterIdServiceClient, error) {
 	client := &ClusterIdServiceClient{
-		TenuredClientInvoke: &protocol.TenuredClientInvoke{},
+		TenuredClientInvoke: protocol.NewClientInvoke(config),
 	}
 	client.serverName = serverName
 	client.reg = reg
+	client.TenuredClientInvoke.Watch(serverName, reg)
 	client.serviceManager = commons.NewServiceManager()
 	client.serviceManager.Add(client.TenuredClientInvoke)
 
 	client.roundLB = registry.NewRoundLoadBalance(serverName, reg)
+	registry.AddFilter(client.roundLB, client.TenuredClientInvoke.IsAvailable)
 	client.serviceManager.Add(client.roundLB)
 
 	return client, nil
@@ -19,7 +19,7 @@ func GetClusterService() (api.ClusterIdService, error) {
 	} else if reg, err := plugins.Registry(*config); err != nil {
 		return nil, err
 	} else {
-		return NewClusterIdServiceClient("tenured_store", cache.NewCacheRegistry(reg))
+		return NewClusterIdServiceClient("tenured_store", cache.NewCacheRegistry(reg), nil)
 	}
 }
 
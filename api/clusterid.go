@@ -10,6 +10,10 @@ var (
 	ClusterIdServiceGet = uint16(1001)
 )
 
+func init() {
+	protocol.RegisterCommandName("ClusterIdService.Get", ClusterIdServiceGet)
+}
+
 //获取分布式ID
 type ClusterIdService interface {
 	Get() (uint64, *protocol.TenuredError)
@@ -8,7 +8,10 @@ type AuthHeader struct {
 	AppAK   string `json:"appAK"`   //应用AK
 	Token   string `json:"token"`   //登录需要的token
 	CloudID string `json:"cloudID"` //用户ID
-	Sign    string `json:"sign"`    //安全校验值
+	Sign    string `json:"sign"`    //安全校验值，查阅 AppSign
+
+	Timestamp int64  `json:"timestamp"` //签名时间，unix秒
+	Nonce     string `json:"nonce"`     //随机字符串，相同应用的nonce不能重复使用
 }
 
 type NotifyMode int
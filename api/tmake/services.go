@@ -13,7 +13,7 @@ import (
 )
 
 var servicePattern = regexp.MustCompile(`^service (\w+)\(([0-9]{4,5})\)( loadBalance\((\w+)\))?[ ]?\{$`)
-var funcPattern = regexp.MustCompile(`^(\w+)\(([ ,\[\]\w]*)\) \(([ ,\[\]\w]*)\)( error\(([,\w]+)\))?( loadBalance\((\w+)\))?( timeout\((\w+)\))?$`)
+var funcPattern = regexp.MustCompile(`^(\w+)\(([ ,\[\]\w]*)\) \(([ ,\[\]\w]*)\)( error\(([,\w]+)\))?( loadBalance\((\w+)\))?( timeout\((\w+)\))?( idempotent)?( hedge)?( oneway)?$`)
 
 type FunParam struct {
 	Name string
@@ -92,6 +92,15 @@ type FuncDef struct {
 	tcd        *TCDInfo
 
 	Timeout string
+
+	//单向方法，服务端不返回响应
+	Oneway bool
+
+	//幂等方法，调用失败时按照重试策略选择其他实例重试
+	Idempotent bool
+
+	//只读方法，响应慢时向其他实例发送对冲请求
+	Hedge bool
 }
 
 func (this *FuncDef) TimeoutDuration() string {
@@ -116,14 +125,27 @@ func (this *FuncDef) ClientBody() string {
 		}
 	}
 
-	b.WriteString(fmt.Sprintf(`
+	invokeCall := "this.Invoke(serverInstance[0], "
+	if this.Idempotent || this.Hedge {
+		//每次重试、对冲请求重新选择实例
+		invokeMethod := "InvokeRetry"
+		if this.Hedge {
+			invokeMethod = "InvokeHedged"
+		}
+		invokeCall = fmt.Sprintf("this.%s(this.%sLB, []interface{}{%s}, ", invokeMethod, this.LoadBalance, loadBanlanceParam)
+		b.WriteString(`
+			var err error
+		`)
+	} else {
+		b.WriteString(fmt.Sprintf(`
 			serverInstance,regKey, err := this.%sLB.Select(%s)
 			if err != nil || len(serverInstance) == 0 || registry.AllNotOK(serverInstance...) {
 				return %s protocol.ErrorRouter()
 			}
 			defer this.%sLB.Return(regKey)
 		`, this.LoadBalance, loadBanlanceParam, strings.Repeat("nil,", len(this.Outs)), this.LoadBalance,
-	))
+		))
+	}
 
 	//header
 	if len(this.Ins) == 0 {
@@ -163,45 +185,49 @@ func (this *FuncDef) ClientBody() string {
 	requestCode := fmt.Sprintf("%s.%s%s", this.tcd.ApiPackageName, this.serviceDef.Name, this.Name)
 	timeoutMillisecond := this.TimeoutDuration()
 	outLength := len(this.Outs)
-	if outLength == 0 {
+	if this.Oneway {
+		b.WriteString(fmt.Sprintf(`
+			return this.InvokeOneway(serverInstance[0], %s, requestHeader,requestBody, %s)
+		`, requestCode, timeoutMillisecond))
+	} else if outLength == 0 {
 		b.WriteString(fmt.Sprintf(`
-			if _, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
+			if _, err = %s%s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
 				return protocol.ConvertError(err)
 			}
 			return nil
-		`, requestCode, timeoutMillisecond))
+		`, invokeCall, requestCode, timeoutMillisecond))
 	} else if outLength == 1 {
 		if "[]byte" == this.Outs[0].Type { //body
 			b.WriteString(fmt.Sprintf(`
 					var respBody []byte
-					if respBody, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
+					if respBody, err = %s%s, requestHeader,requestBody, %s, nil); !commons.IsNil(err) {
 						return nil,protocol.ConvertError(err)
 					}else{
 						return respBody,nil
 					}
-				`, requestCode, timeoutMillisecond))
+				`, invokeCall, requestCode, timeoutMillisecond))
 		} else if isBase(this.Outs[0].Type) {
 			log.Panic("方法" + this.serviceDef.Name + "." + this.Name + "返回值定义错误，只能为 struct,[]byte两种类型。")
 		} else { //from header
 			b.WriteString(fmt.Sprintf(`
 				respHeader := &%s{}
-				if _, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
+				if _, err = %s%s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
 					return nil,protocol.ConvertError(err)
 				}else{
 					return respHeader,nil
 				}
-			`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), requestCode, timeoutMillisecond))
+			`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), invokeCall, requestCode, timeoutMillisecond))
 		}
 	} else {
 		b.WriteString(fmt.Sprintf(`
 			respHeader := &%s{}
 			var respBody []byte
-			if respBody, err = this.Invoke(serverInstance[0], %s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
+			if respBody, err = %s%s, requestHeader,requestBody, %s, respHeader); !commons.IsNil(err) {
 				return nil, nil, protocol.ConvertError(err)
 			}else{
 				return respHeader, respBody, nil
 			}
-		`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), requestCode, timeoutMillisecond))
+		`, (this.tcd.ApiPackageName + "." + this.Outs[0].Type), invokeCall, requestCode, timeoutMillisecond))
 	}
 	return string(b.Bytes())
 }
@@ -265,6 +291,15 @@ func (this *FuncDef) InvokeBody() string {
 		`)
 	}
 
+	if this.Oneway {
+		ftl(`
+			if err := service.{{.Method}}({{.Request}}); err != nil {
+				logger.Error("{{.Method}} oneway error: ", err)
+			}
+		`, st, b)
+		return string(b.Bytes())
+	}
+
 	if len(this.Outs) == 0 {
 
 	} else if len(this.Outs) == 1 {
@@ -363,6 +398,9 @@ func (this *ServicesDef) Add(addLines []string, info *TCDInfo) error {
 		if gs[9] != "" {
 			funDef.Timeout = gs[9]
 		}
+		funDef.Idempotent = gs[10] != ""
+		funDef.Hedge = gs[11] != ""
+		funDef.Oneway = gs[12] != ""
 
 		if funDef.LoadBalance == "none" {
 			this.Imports.AddInterface(TenuredHome+"/commons/registry", "")
@@ -386,6 +424,13 @@ func (this *ServicesDef) Add(addLines []string, info *TCDInfo) error {
 			}
 		}
 		//errorss := gs[5]
+		if funDef.Oneway && len(funDef.Outs) > 0 {
+			return errors.New(fmt.Sprintf("服务 %s.%s() 单向方法不能定义返回值", serviceDef.Name, funDef.Name))
+		}
+		//单向方法没有响应无法判断是否失败，none负载每次选择都会移动到下一个节点
+		if (funDef.Idempotent || funDef.Hedge) && (funDef.Oneway || funDef.LoadBalance == "none") {
+			return errors.New(fmt.Sprintf("服务 %s.%s() 单向方法和none负载不能定义为幂等或者对冲方法", serviceDef.Name, funDef.Name))
+		}
 
 		serviceDef.Funcs = append(serviceDef.Funcs, funDef)
 	}
@@ -403,6 +448,13 @@ var (
 {{end}}{{end}}
 )
 
+{{if .Services}}
+func init() {
+{{range $i,$s := .Services}}{{range .Funcs}}
+	protocol.RegisterCommandName("{{$s.Name}}.{{.Name}}", {{$s.Name}}{{.Name}}){{end}}{{end}}
+}
+{{end}}
+
 {{range .Services}}
 {{.Desc}}
 type {{.Name}} interface {
@@ -444,17 +496,20 @@ func (this *{{$s.Name}}Client) {{.Name}}({{if eq .LoadBalance "none" }} gl *regi
 }
 {{end}}
 
-func New{{.Name}}Client(serverName string, reg registry.ServiceRegistry) (*{{.Name}}Client, error){
+//config为空时使用默认的客户端配置
+func New{{.Name}}Client(serverName string, reg registry.ServiceRegistry, config *protocol.ClientConfig) (*{{.Name}}Client, error){
 	client := &{{.Name}}Client{
-		TenuredClientInvoke: &protocol.TenuredClientInvoke{},
+		TenuredClientInvoke: protocol.NewClientInvoke(config),
 	}
 	client.serverName = serverName
 	client.reg = reg
+	client.TenuredClientInvoke.Watch(serverName, reg)
 	client.serviceManager = commons.NewServiceManager()
 	client.serviceManager.Add(client.TenuredClientInvoke)
 
 	{{range $name,$v := .DefinedLoadBalance}}
 	client.{{$name}}LB = {{$v}}(serverName,reg)
+	registry.AddFilter(client.{{$name}}LB, client.TenuredClientInvoke.IsAvailable)
 	client.serviceManager.Add(client.{{$name}}LB)
 	{{end}}
 
@@ -480,11 +535,11 @@ func New{{.Name}}Invoke(tenuredServer *protocol.TenuredServer, service {{$.TCD.A
 	{
 		executor := manager.Get("{{$s.Name}}.{{.Name}}")
 		tenuredServer.RegisterCommandProcesser({{$.TCD.ApiPackageName}}.{{$s.Name}}{{.Name}}, func(channel remoting.RemotingChannel, request *protocol.TenuredCommand) {
-			response := protocol.NewACK(request.ID())
+			{{if .Oneway}}{{.InvokeBody}}{{else}}response := protocol.NewResponse(request)
 			{{.InvokeBody}}
 			if err := channel.Write(response, {{.TimeoutDuration}}); err != nil {
 				logger.Error("{{$s.Name}}.{{.Name}} write error: ", err)
-			}
+			}{{end}}
 		}, executor)
 	}
 	{{end}}
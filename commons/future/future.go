@@ -54,7 +54,7 @@ func (self *futureWapper) is(check ...uint32) bool {
 }
 
 func (self *futureWapper) Cancel() bool {
-	if self.status != S_RUNNING {
+	if self.atomicGet() != S_RUNNING {
 		return false
 	}
 	if self.atomicSet(S_RUNNING, S_CANCEL) {
@@ -73,18 +73,13 @@ func (self *futureWapper) IsDone() bool {
 	return self.is(S_EXCEPTION, S_OVER, S_CANCEL)
 }
 
+//结果在状态修改之后设置，所以需要等待resultChan关闭后再读取
 func (self *futureWapper) Get() (interface{}, error) {
-	if self.IsDone() {
-		return self.result, self.err
-	}
 	<-self.resultChan
 	return self.result, self.err
 }
 
 func (self *futureWapper) GetWithTimeout(timeout time.Duration) (interface{}, error) {
-	if self.IsDone() {
-		return self.result, self.err
-	}
 	select {
 	case <-self.resultChan:
 		return self.result, self.err
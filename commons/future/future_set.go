@@ -5,7 +5,7 @@ type SetFuture struct {
 }
 
 func (self *SetFuture) Set(result interface{}) bool {
-	if self.status != S_RUNNING {
+	if self.atomicGet() != S_RUNNING {
 		return false
 	}
 	if self.atomicSet(S_RUNNING, S_OVER) {
@@ -16,7 +16,7 @@ func (self *SetFuture) Set(result interface{}) bool {
 	return false
 }
 func (self *SetFuture) Exception(err error) bool {
-	if self.status != S_RUNNING {
+	if self.atomicGet() != S_RUNNING {
 		return false
 	}
 	if self.atomicSet(S_RUNNING, S_EXCEPTION) {
@@ -16,6 +16,9 @@ const Registry = "consul://127.0.0.1:8500"
 const KeyDataPath = "tenured.dataPath"
 const DataPath = "/data/tenured"
 
+//集群密钥，集群内模块认证使用
+const KeyClusterSecret = "tenured.cluster.secret"
+
 func Get(key, value string) string {
 	if val, has := os.LookupEnv(key); has {
 		return val
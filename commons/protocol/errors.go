@@ -57,6 +57,29 @@ func ErrorRouter() *TenuredError {
 	return &TenuredError{code: "1005", message: "No valid route"}
 }
 
+const errorCanceledCode = "1006"
+
+//请求超过截止时间或者被请求方取消，err为Context的错误
+func ErrorCanceled(err error) *TenuredError {
+	return &TenuredError{code: errorCanceledCode, message: err.Error()}
+}
+
+//是否为ErrorCanceled返回的错误
+func IsCanceled(err error) bool {
+	terr, ok := err.(*TenuredError)
+	return ok && terr.Is(errorCanceledCode)
+}
+
+//模块或者应用没有调用此请求的权限
+func ErrorForbidden(code uint16) *TenuredError {
+	return &TenuredError{code: "1007", message: fmt.Sprintf("forbidden command(%d)", code)}
+}
+
+//服务实例已熔断，请求没有发送
+func ErrorCircuitOpen(address string) *TenuredError {
+	return &TenuredError{code: "1008", message: "circuit breaker is open: " + address}
+}
+
 func NewError(code, message string) *TenuredError {
 	return &TenuredError{code: code, message: message}
 }
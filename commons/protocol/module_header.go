@@ -1,6 +1,17 @@
 package protocol
 
-import "fmt"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//集群认证签名使用的属性名称
+const AUTH_ATTRIBUTE_TIMESTAMP = "timestamp"
+const AUTH_ATTRIBUTE_NONCE = "nonce"
+const AUTH_ATTRIBUTE_SIGN = "sign"
 
 type AuthHeader struct {
 	Module     string            `json:"module"`
@@ -12,6 +23,27 @@ func (this *AuthHeader) AddAttributes(key, value string) {
 	this.Attributes[key] = value
 }
 
+func (this *AuthHeader) clone() *AuthHeader {
+	header := &AuthHeader{Attributes: map[string]string{}}
+	if this != nil {
+		header.Module, header.Address = this.Module, this.Address
+		for k, v := range this.Attributes {
+			header.Attributes[k] = v
+		}
+	}
+	return header
+}
+
+//使用集群密钥签名，每次认证使用新的时间和nonce
+func (this *AuthHeader) sign(secret string) {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	timestamp := time.Now().Unix()
+	this.Attributes[AUTH_ATTRIBUTE_TIMESTAMP] = strconv.FormatInt(timestamp, 10)
+	this.Attributes[AUTH_ATTRIBUTE_NONCE] = hex.EncodeToString(nonce)
+	this.Attributes[AUTH_ATTRIBUTE_SIGN] = HmacSign(secret, this.Module, timestamp, this.Attributes[AUTH_ATTRIBUTE_NONCE])
+}
+
 func (this *AuthHeader) String() string {
 	return fmt.Sprintf("AuthHeader{module=%s, address=%s, attrs=%v}",
 		this.Module, this.Address, this.Attributes)
@@ -1,11 +1,18 @@
 package protocol
 
 import (
+	"errors"
+	"fmt"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"strconv"
+	"time"
 )
 
 const auth_attributes_name = "auth_token"
 
+//认证成功后channel属性中保存的模块名称
+const AUTH_ATTRIBUTE_MODULE = "auth_module"
+
 type TenuredAuthChecker interface {
 	Auth(channel remoting.RemotingChannel, command *TenuredCommand) error
 	IsAuthed(channel remoting.RemotingChannel) bool
@@ -20,6 +27,9 @@ func (this *ModuleAuthChecker) Auth(channel remoting.RemotingChannel, command *T
 		return err
 	} else {
 		channel.Attributes()[auth_attributes_name] = true
+		if header.Module != "" {
+			channel.Attributes()[AUTH_ATTRIBUTE_MODULE] = header.Module
+		}
 	}
 
 	return nil
@@ -33,3 +43,72 @@ func (this *ModuleAuthChecker) IsAuthed(channel remoting.RemotingChannel) bool {
 	_, has := attrs[auth_attributes_name]
 	return has
 }
+
+//基于TLS客户端证书的认证，只有证书CommonName在允许列表中的连接才能通过认证。
+//需要服务端开启 remoting.TLSConfig.ClientAuth
+type CertificateAuthChecker struct {
+	ModuleAuthChecker
+	AllowCommonNames []string
+}
+
+func (this *CertificateAuthChecker) Auth(channel remoting.RemotingChannel, command *TenuredCommand) error {
+	commonName, _ := channel.Attributes()[remoting.TLS_PEER_COMMON_NAME].(string)
+	if commonName == "" {
+		return ErrorNoAuth()
+	}
+	for _, allow := range this.AllowCommonNames {
+		if allow == commonName {
+			return this.ModuleAuthChecker.Auth(channel, command)
+		}
+	}
+	return ErrorInvalidAuth()
+}
+
+//集群内模块认证，校验使用集群密钥的签名，并且只允许AllowModules中的模块连接
+type ClusterAuthChecker struct {
+	ModuleAuthChecker
+	//签名时间误差和nonce重放校验
+	SignVerifier
+	Secret string
+
+	//允许连接的模块，为空时允许所有签名正确的模块
+	AllowModules []string
+}
+
+func (this *ClusterAuthChecker) Auth(channel remoting.RemotingChannel, command *TenuredCommand) error {
+	header := &AuthHeader{}
+	if err := command.GetHeader(header); err != nil {
+		return err
+	}
+	if header.Module == "" || header.Attributes == nil {
+		return ErrorNoModule()
+	}
+	if len(this.AllowModules) > 0 && !contains(this.AllowModules, header.Module) {
+		return errors.New(fmt.Sprintf("module %s not allowed", header.Module))
+	}
+	if err := this.verify(header); err != nil {
+		return err
+	}
+	return this.ModuleAuthChecker.Auth(channel, command)
+}
+
+func (this *ClusterAuthChecker) verify(header *AuthHeader) error {
+	timestamp, err := strconv.ParseInt(header.Attributes[AUTH_ATTRIBUTE_TIMESTAMP], 10, 64)
+	if err != nil {
+		return errors.New(fmt.Sprintf("module %s sign timestamp: %v", header.Module, err))
+	}
+	if err := this.Verify(this.Secret, header.Module, timestamp,
+		header.Attributes[AUTH_ATTRIBUTE_NONCE], header.Attributes[AUTH_ATTRIBUTE_SIGN], time.Now()); err != nil {
+		return errors.New(fmt.Sprintf("module %v", err))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
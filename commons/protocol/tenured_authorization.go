@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+//认证成功后channel属性中保存的应用角色([]string)
+const AUTH_ATTRIBUTE_ROLES = "auth_roles"
+
+//拒绝的请求记录到此日志中
+var auditLogger *logrus.Logger
+
+func init() {
+	auditLogger = logs.GetLogger("audit")
+}
+
+var commandNamesLock sync.RWMutex
+var commandNames = map[string]uint16{}
+
+//注册请求码的名称，tmake生成的代码使用 服务名.方法名 注册
+func RegisterCommandName(name string, code uint16) {
+	commandNamesLock.Lock()
+	defer commandNamesLock.Unlock()
+	commandNames[name] = code
+}
+
+func CommandCode(name string) (uint16, bool) {
+	commandNamesLock.RLock()
+	defer commandNamesLock.RUnlock()
+	code, has := commandNames[name]
+	return code, has
+}
+
+//请求码允许调用的模块或者应用角色，满足其一即可调用
+type AuthorizationPolicy struct {
+	//请求码或者注册的名称，例如：2001、AccountService.Apply
+	Command string   `json:"command" yaml:"command"`
+	Modules []string `json:"modules,omitempty" yaml:"modules,omitempty"`
+	Roles   []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+}
+
+type AuthorizationConfig struct {
+	//没有配置策略的请求码是否允许调用
+	DefaultAllow bool                   `json:"defaultAllow" yaml:"defaultAllow"`
+	Policies     []*AuthorizationPolicy `json:"policies,omitempty" yaml:"policies,omitempty"`
+}
+
+//请求授权，在处理器执行之前拒绝没有权限的请求
+type Authorization struct {
+	defaultAllow bool
+	policies     map[uint16]*AuthorizationPolicy
+}
+
+func NewAuthorization(config *AuthorizationConfig) (*Authorization, error) {
+	authorization := &Authorization{defaultAllow: true, policies: map[uint16]*AuthorizationPolicy{}}
+	if config == nil {
+		return authorization, nil
+	}
+	authorization.defaultAllow = config.DefaultAllow
+	for _, policy := range config.Policies {
+		code, err := ParseCommandCode(policy.Command)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("unknown authorization command: %s", policy.Command))
+		}
+		if _, has := authorization.policies[code]; has {
+			return nil, errors.New(fmt.Sprintf("duplicate authorization policy: %s", policy.Command))
+		}
+		authorization.policies[code] = policy
+	}
+	return authorization, nil
+}
+
+//channel是否可以调用请求码
+func (this *Authorization) Allow(channel remoting.RemotingChannel, code uint16) bool {
+	policy, has := this.policies[code]
+	if !has {
+		return this.defaultAllow
+	}
+	attrs := channel.Attributes()
+	module, _ := attrs[AUTH_ATTRIBUTE_MODULE].(string)
+	if module != "" && contains(policy.Modules, module) {
+		return true
+	}
+	roles, _ := attrs[AUTH_ATTRIBUTE_ROLES].([]string)
+	for _, role := range roles {
+		if contains(policy.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+//授权拦截器，需要注册到TenuredServer.Use
+func (this *Authorization) Interceptor() ServerInterceptor {
+	return func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+		if !this.Allow(channel, request.code) {
+			attrs := channel.Attributes()
+			auditLogger.WithFields(logrus.Fields{
+				"address": channel.RemoteAddr(), "code": request.code, "id": request.id,
+				"module": attrs[AUTH_ATTRIBUTE_MODULE], "roles": attrs[AUTH_ATTRIBUTE_ROLES],
+			}).Warn("forbidden command")
+			return nil, ErrorForbidden(request.code)
+		}
+		return next(channel, request)
+	}
+}
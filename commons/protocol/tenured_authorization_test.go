@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuthorization(t *testing.T) {
+	RegisterCommandName("TestService.Apply", uint16(9001))
+	authorization, err := NewAuthorization(&AuthorizationConfig{
+		Policies: []*AuthorizationPolicy{
+			{Command: "TestService.Apply", Modules: []string{"tenured_console"}},
+			{Command: "9002", Roles: []string{"admin"}},
+		},
+	})
+	assert.Nil(t, err)
+
+	console := &authTestChannel{attrs: map[string]interface{}{AUTH_ATTRIBUTE_MODULE: "tenured_console"}}
+	admin := &authTestChannel{attrs: map[string]interface{}{AUTH_ATTRIBUTE_ROLES: []string{"user", "admin"}}}
+	assert.True(t, authorization.Allow(console, 9001))
+	assert.False(t, authorization.Allow(admin, 9001))
+	assert.True(t, authorization.Allow(admin, 9002))
+	assert.False(t, authorization.Allow(console, 9002))
+	//没有配置策略的请求码
+	assert.False(t, authorization.Allow(console, 9003))
+
+	_, forbidden := authorization.Interceptor()(admin, &TenuredCommand{code: 9001}, func(remoting.RemotingChannel, *TenuredCommand) (*TenuredCommand, *TenuredError) {
+		return nil, nil
+	})
+	assert.Equal(t, "1007", forbidden.Code())
+
+	_, err = NewAuthorization(&AuthorizationConfig{Policies: []*AuthorizationPolicy{{Command: "TestService.Unknown"}}})
+	assert.NotNil(t, err)
+	_, err = NewAuthorization(&AuthorizationConfig{Policies: []*AuthorizationPolicy{{Command: "9001"}, {Command: "TestService.Apply"}}})
+	assert.NotNil(t, err)
+
+	authorization, err = NewAuthorization(nil)
+	assert.Nil(t, err)
+	assert.True(t, authorization.Allow(console, 9001))
+}
@@ -0,0 +1,232 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	//正常调用
+	BREAKER_CLOSED BreakerState = iota
+	//熔断，不再选择此实例
+	BREAKER_OPEN
+	//熔断超时后放行少量请求探测实例是否恢复
+	BREAKER_HALF_OPEN
+)
+
+func (this BreakerState) String() string {
+	switch this {
+	case BREAKER_OPEN:
+		return "open"
+	case BREAKER_HALF_OPEN:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type BreakerConfig struct {
+	//统计错误率的时间窗口
+	Window time.Duration
+
+	//时间窗口内请求数达到此值才计算错误率
+	MinRequests int
+
+	//错误率达到此值时熔断，0-1
+	ErrorRate float64
+
+	//响应时间超过此值的调用记为失败，0不统计
+	SlowCall time.Duration
+
+	//熔断后经过此时间进入半开状态
+	OpenTimeout time.Duration
+
+	//半开状态放行的探测请求数，全部成功后恢复
+	HalfOpenRequests int
+}
+
+func DefaultBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		Window:           time.Second * 10,
+		MinRequests:      20,
+		ErrorRate:        0.5,
+		SlowCall:         time.Second * 2,
+		OpenTimeout:      time.Second * 5,
+		HalfOpenRequests: 3,
+	}
+}
+
+type instanceBreaker struct {
+	state BreakerState
+
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	openedAt time.Time
+
+	//半开状态下已放行和已成功的探测请求
+	probes    int
+	successes int
+}
+
+//按照服务实例地址熔断，错误率或者慢调用过高的实例暂时不再调用
+type CircuitBreaker struct {
+	config *BreakerConfig
+
+	lock     sync.Mutex
+	breakers map[string]*instanceBreaker
+
+	//状态变化时回调，在锁外调用
+	OnStateChange func(address string, from, to BreakerState)
+
+	now func() time.Time
+}
+
+func NewCircuitBreaker(config *BreakerConfig) *CircuitBreaker {
+	if config == nil {
+		config = DefaultBreakerConfig()
+	}
+	return &CircuitBreaker{
+		config: config, now: time.Now,
+		breakers: map[string]*instanceBreaker{},
+	}
+}
+
+func (this *CircuitBreaker) breakerOf(address string) *instanceBreaker {
+	breaker, has := this.breakers[address]
+	if !has {
+		breaker = &instanceBreaker{windowStart: this.now()}
+		this.breakers[address] = breaker
+	}
+	return breaker
+}
+
+//熔断超时后转为半开状态，返回变化前的状态
+func (this *CircuitBreaker) refresh(breaker *instanceBreaker, now time.Time) BreakerState {
+	state := breaker.state
+	if state == BREAKER_OPEN && now.Sub(breaker.openedAt) >= this.config.OpenTimeout {
+		breaker.state, breaker.probes, breaker.successes = BREAKER_HALF_OPEN, 0, 0
+	} else if state == BREAKER_CLOSED && now.Sub(breaker.windowStart) >= this.config.Window {
+		breaker.windowStart, breaker.requests, breaker.failures = now, 0, 0
+	}
+	return state
+}
+
+//实例是否可以被负载均衡选中，不占用半开状态的探测请求
+func (this *CircuitBreaker) Available(address string) bool {
+	this.lock.Lock()
+	breaker, has := this.breakers[address]
+	if !has {
+		this.lock.Unlock()
+		return true
+	}
+	from := this.refresh(breaker, this.now())
+	to := breaker.state
+	available := to == BREAKER_CLOSED || (to == BREAKER_HALF_OPEN && breaker.probes < this.config.HalfOpenRequests)
+	this.lock.Unlock()
+
+	this.changed(address, from, to)
+	return available
+}
+
+//调用前获取许可，半开状态时占用一个探测请求
+func (this *CircuitBreaker) Acquire(address string) bool {
+	this.lock.Lock()
+	breaker := this.breakerOf(address)
+	from := this.refresh(breaker, this.now())
+	to, acquired := breaker.state, true
+	if to == BREAKER_OPEN {
+		acquired = false
+	} else if to == BREAKER_HALF_OPEN {
+		if acquired = breaker.probes < this.config.HalfOpenRequests; acquired {
+			breaker.probes++
+		}
+	}
+	this.lock.Unlock()
+
+	this.changed(address, from, to)
+	return acquired
+}
+
+//记录调用结果，failure为true表示通讯失败或者超时
+func (this *CircuitBreaker) Done(address string, failure bool, elapsed time.Duration) {
+	if this.config.SlowCall > 0 && elapsed >= this.config.SlowCall {
+		failure = true
+	}
+	now := this.now()
+
+	this.lock.Lock()
+	breaker := this.breakerOf(address)
+	from := this.refresh(breaker, now)
+	switch breaker.state {
+	case BREAKER_CLOSED:
+		breaker.requests++
+		if failure {
+			breaker.failures++
+		}
+		if breaker.requests >= this.config.MinRequests &&
+			float64(breaker.failures) >= this.config.ErrorRate*float64(breaker.requests) {
+			breaker.state, breaker.openedAt = BREAKER_OPEN, now
+		}
+	case BREAKER_HALF_OPEN:
+		if failure {
+			breaker.state, breaker.openedAt = BREAKER_OPEN, now
+		} else if breaker.successes++; breaker.successes >= this.config.HalfOpenRequests {
+			breaker.state = BREAKER_CLOSED
+			breaker.windowStart, breaker.requests, breaker.failures = now, 0, 0
+		}
+	}
+	to := breaker.state
+	this.lock.Unlock()
+
+	this.changed(address, from, to)
+}
+
+//调用被主动取消，不记录结果，归还半开状态占用的探测请求
+func (this *CircuitBreaker) Release(address string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if breaker, has := this.breakers[address]; has && breaker.state == BREAKER_HALF_OPEN && breaker.probes > 0 {
+		breaker.probes--
+	}
+}
+
+func (this *CircuitBreaker) changed(address string, from, to BreakerState) {
+	if from == to {
+		return
+	}
+	logger.Warnf("circuit breaker %s: %s -> %s", address, from, to)
+	if this.OnStateChange != nil {
+		this.OnStateChange(address, from, to)
+	}
+}
+
+//删除实例的熔断统计，实例下线后调用
+func (this *CircuitBreaker) Remove(address string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.breakers, address)
+}
+
+//实例的熔断状态，没有调用过的实例为关闭状态
+func (this *CircuitBreaker) State(address string) BreakerState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if breaker, has := this.breakers[address]; has {
+		return breaker.state
+	}
+	return BREAKER_CLOSED
+}
+
+//所有调用过的实例的熔断状态
+func (this *CircuitBreaker) States() map[string]BreakerState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	states := make(map[string]BreakerState, len(this.breakers))
+	for address, breaker := range this.breakers {
+		states[address] = breaker.state
+	}
+	return states
+}
@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(&BreakerConfig{
+		Window: time.Second * 10, MinRequests: 4, ErrorRate: 0.5,
+		SlowCall: time.Second, OpenTimeout: time.Second * 5, HalfOpenRequests: 2,
+	})
+	breaker.now = func() time.Time { return now }
+	changes := make([]BreakerState, 0)
+	breaker.OnStateChange = func(address string, from, to BreakerState) {
+		changes = append(changes, to)
+	}
+	address := "127.0.0.1:6072"
+
+	assert.True(t, breaker.Available(address))
+	breaker.Done(address, false, time.Millisecond)
+	breaker.Done(address, true, time.Millisecond)
+	breaker.Done(address, false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State(address))
+	//慢调用记为失败
+	breaker.Done(address, false, time.Second)
+	assert.Equal(t, BREAKER_OPEN, breaker.State(address))
+	assert.False(t, breaker.Available(address))
+	assert.False(t, breaker.Acquire(address))
+
+	//熔断超时后半开，只放行探测请求
+	now = now.Add(time.Second * 5)
+	assert.True(t, breaker.Available(address))
+	assert.Equal(t, BREAKER_HALF_OPEN, breaker.State(address))
+	assert.True(t, breaker.Acquire(address))
+	assert.True(t, breaker.Acquire(address))
+	assert.False(t, breaker.Acquire(address))
+	assert.False(t, breaker.Available(address))
+	breaker.Done(address, true, time.Millisecond)
+	assert.Equal(t, BREAKER_OPEN, breaker.State(address))
+
+	now = now.Add(time.Second * 5)
+	assert.True(t, breaker.Acquire(address))
+	assert.True(t, breaker.Acquire(address))
+	breaker.Done(address, false, time.Millisecond)
+	breaker.Done(address, false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State(address))
+	assert.Equal(t, []BreakerState{BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_OPEN, BREAKER_HALF_OPEN, BREAKER_CLOSED}, changes)
+
+	//时间窗口外的请求不计算
+	breaker.Done(address, true, time.Millisecond)
+	breaker.Done(address, true, time.Millisecond)
+	now = now.Add(time.Second * 10)
+	breaker.Done(address, true, time.Millisecond)
+	breaker.Done(address, false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State(address))
+	assert.Equal(t, map[string]BreakerState{address: BREAKER_CLOSED}, breaker.States())
+}
+
+//取消的探测请求不记录结果，半开状态不会因此恢复
+func TestCircuitBreaker_Release(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(&BreakerConfig{
+		Window: time.Second * 10, MinRequests: 1, ErrorRate: 1, OpenTimeout: time.Second, HalfOpenRequests: 1,
+	})
+	breaker.now = func() time.Time { return now }
+	address := "127.0.0.1:6073"
+
+	breaker.Done(address, true, time.Millisecond)
+	now = now.Add(time.Second)
+	assert.True(t, breaker.Acquire(address))
+	assert.False(t, breaker.Available(address))
+	breaker.Release(address)
+	assert.Equal(t, BREAKER_HALF_OPEN, breaker.State(address))
+	assert.True(t, breaker.Available(address))
+
+	assert.True(t, breaker.Acquire(address))
+	breaker.Done(address, false, time.Millisecond)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State(address))
+	//关闭状态下没有需要归还的探测请求
+	breaker.Release(address)
+	assert.Equal(t, BREAKER_CLOSED, breaker.State(address))
+}
+
+func TestTenuredClientInvoke_CircuitBreaker(t *testing.T) {
+	invoke := NewClientInvoke(nil)
+	invoke.SetCircuitBreaker(&BreakerConfig{Window: time.Minute, MinRequests: 2, ErrorRate: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+
+	down := &registry.ServerInstance{Address: "pipe://tenured-breaker-down", Status: "OK"}
+	for i := 0; i < 2; i++ {
+		_, err := invoke.Invoke(down, HELLO, nil, nil, time.Second, nil)
+		assert.NotEqual(t, "1008", err.Code())
+	}
+	assert.Equal(t, BREAKER_OPEN, invoke.BreakerState(down))
+	assert.False(t, invoke.IsAvailable(down))
+	//熔断后请求不再发送
+	_, err := invoke.Invoke(down, HELLO, nil, nil, time.Second, nil)
+	assert.Equal(t, "1008", err.Code())
+
+	invoke.SetCircuitBreaker(nil)
+	assert.Equal(t, BREAKER_CLOSED, invoke.BreakerState(down))
+}
+
+//只记录订阅的注册中心
+type breakerTestRegistry struct {
+	listeners map[string]registry.RegistryNotifyListener
+}
+
+func (this *breakerTestRegistry) Register(serverInstance *registry.ServerInstance) error {
+	return nil
+}
+
+func (this *breakerTestRegistry) Unregister(serverId string) error {
+	return nil
+}
+
+func (this *breakerTestRegistry) Subscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	this.listeners[serverName] = listener
+	return nil
+}
+
+func (this *breakerTestRegistry) Unsubscribe(serverName string, listener registry.RegistryNotifyListener) error {
+	delete(this.listeners, serverName)
+	return nil
+}
+
+func (this *breakerTestRegistry) Lookup(serverName string, tags []string) ([]*registry.ServerInstance, error) {
+	return nil, nil
+}
+
+func TestTenuredClientInvoke_BreakerEviction(t *testing.T) {
+	reg := &breakerTestRegistry{listeners: map[string]registry.RegistryNotifyListener{}}
+	invoke := NewClientInvoke(nil)
+	invoke.SetCircuitBreaker(&BreakerConfig{Window: time.Minute, MinRequests: 1, ErrorRate: 1, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	invoke.Watch("tenured-breaker", reg)
+	assert.Nil(t, invoke.Start())
+
+	down := &registry.ServerInstance{Address: "pipe://tenured-breaker-evict", Status: "OK"}
+	_, _ = invoke.Invoke(down, HELLO, nil, nil, time.Second, nil)
+	assert.Equal(t, BREAKER_OPEN, invoke.BreakerState(down))
+
+	//注册不影响熔断状态，注销后删除
+	reg.listeners["tenured-breaker"](registry.REGISTER, []*registry.ServerInstance{down})
+	assert.Equal(t, BREAKER_OPEN, invoke.BreakerState(down))
+	reg.listeners["tenured-breaker"](registry.UNREGISTER, []*registry.ServerInstance{down})
+	assert.Equal(t, map[string]BreakerState{}, invoke.CircuitBreaker().States())
+
+	invoke.Shutdown(true)
+	assert.Equal(t, 0, len(reg.listeners))
+}
@@ -0,0 +1,170 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//抓包记录的方向
+const (
+	CAPTURE_IN  = "in"
+	CAPTURE_OUT = "out"
+)
+
+//抓包文件中的一条记录，文件每行一个JSON
+type CaptureRecord struct {
+	Time time.Time `json:"time"`
+
+	//对端地址
+	Address string `json:"address"`
+
+	//in: 收到的消息，out: 发送的消息
+	Direction string `json:"direction"`
+
+	Id      uint32 `json:"id"`
+	Code    uint16 `json:"code"`
+	Version uint8  `json:"version"`
+	Flag    int    `json:"flag"`
+	Timeout uint32 `json:"timeout,omitempty"`
+	Header  []byte `json:"header,omitempty"`
+	Body    []byte `json:"body,omitempty"`
+}
+
+func (this *CaptureRecord) IsACK() bool {
+	return (this.Flag & FLAG_ACK) == FLAG_ACK
+}
+
+func (this *CaptureRecord) IsOneway() bool {
+	return (this.Flag & FLAG_ONEWAY) == FLAG_ONEWAY
+}
+
+//还原为请求，使用新的请求ID
+func (this *CaptureRecord) Request() *TenuredCommand {
+	command := NewRequest(this.Code)
+	command.Version = this.Version
+	command.flag = this.Flag & FLAG_ONEWAY
+	command.header = this.Header
+	command.Body = this.Body
+	return command
+}
+
+//抓包文件，记录解码后的TenuredCommand
+type Capture struct {
+	lock    sync.Mutex
+	writer  *bufio.Writer
+	closer  io.Closer
+	encoder *json.Encoder
+}
+
+func NewCapture(writer io.Writer) *Capture {
+	capture := &Capture{writer: bufio.NewWriter(writer)}
+	capture.encoder = json.NewEncoder(capture.writer)
+	if closer, match := writer.(io.Closer); match {
+		capture.closer = closer
+	}
+	return capture
+}
+
+//以追加方式打开抓包文件
+func OpenCapture(path string) (*Capture, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCapture(file), nil
+}
+
+func (this *Capture) Record(address, direction string, command *TenuredCommand) error {
+	record := &CaptureRecord{
+		Time: time.Now(), Address: address, Direction: direction,
+		Id: command.id, Code: command.code, Version: command.Version, Flag: command.flag,
+		Timeout: command.timeout, Header: command.header, Body: command.Body,
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.encoder.Encode(record); err != nil {
+		return err
+	}
+	return this.writer.Flush()
+}
+
+func (this *Capture) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	err := this.writer.Flush()
+	if this.closer != nil {
+		if closeErr := this.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//读取抓包文件中的所有记录
+func ReadCapture(reader io.Reader) ([]*CaptureRecord, error) {
+	records := make([]*CaptureRecord, 0)
+	decoder := json.NewDecoder(reader)
+	for {
+		record := &CaptureRecord{}
+		if err := decoder.Decode(record); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+//服务端的抓包开关，所有channel的coder共享
+type captureSwitch struct {
+	lock    sync.RWMutex
+	capture *Capture
+}
+
+func (this *captureSwitch) swap(capture *Capture) *Capture {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	old := this.capture
+	this.capture = capture
+	return old
+}
+
+func (this *captureSwitch) record(channel remoting.RemotingChannel, direction string, command *TenuredCommand) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.capture == nil {
+		return
+	}
+	if err := this.capture.Record(channel.RemoteAddr(), direction, command); err != nil {
+		logger.Warnf("capture %s command %d error: %s", direction, command.id, err)
+	}
+}
+
+//开始抓包，收到和发送的消息追加到path文件中
+func (this *TenuredServer) StartCapture(path string) error {
+	capture, err := OpenCapture(path)
+	if err != nil {
+		return err
+	}
+	this.SetCapture(capture)
+	logger.Infof("start capture to %s", path)
+	return nil
+}
+
+//设置抓包文件，为nil时停止抓包，之前的抓包文件被关闭
+func (this *TenuredServer) SetCapture(capture *Capture) {
+	if old := this.capture.swap(capture); old != nil {
+		if err := old.Close(); err != nil {
+			logger.Warnf("close capture error: %s", err)
+		}
+	}
+}
+
+func (this *TenuredServer) StopCapture() {
+	this.SetCapture(nil)
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTenured_CaptureReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenured-capture")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.jsonl")
+
+	address := "pipe://tenured-capture"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	//HELLO原样返回，HELLO+1返回调用次数
+	counter := int32(0)
+	for _, code := range []uint16{HELLO, HELLO + 1} {
+		code := code
+		server.RegisterCommandProcesser(code, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			response := NewResponse(command)
+			if code == HELLO {
+				response.Body = command.Body
+			} else {
+				response.Body = []byte(strconv.Itoa(int(atomic.AddInt32(&counter, 1))))
+			}
+			_ = channel.Write(response, time.Second)
+		}, nil)
+	}
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+	assert.Nil(t, server.StartCapture(path))
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	for _, code := range []uint16{HELLO, HELLO + 1, HELLO} {
+		request := NewRequest(code)
+		assert.Nil(t, request.SetHeader(&AuthHeader{Module: "header"}))
+		request.Body = []byte("body")
+		_, err := client.Invoke(address, request, time.Second)
+		assert.Nil(t, err)
+	}
+	server.StopCapture()
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	records, err := ReadCapture(file)
+	_ = file.Close()
+	assert.Nil(t, err)
+	//认证请求和响应，三个请求和响应
+	assert.Equal(t, 8, len(records))
+	assert.Equal(t, CAPTURE_IN, records[2].Direction)
+	assert.Equal(t, HELLO, records[2].Code)
+	assert.Equal(t, "body", string(records[2].Body))
+	assert.True(t, strings.Contains(string(records[2].Header), `"module":"header"`))
+	assert.Equal(t, CAPTURE_OUT, records[3].Direction)
+	assert.True(t, records[3].IsACK())
+	assert.Equal(t, records[2].Id, records[3].Id)
+
+	report := Replay(client, address, records, &ReplayOptions{Speed: 0})
+	assert.Equal(t, 3, report.Requests)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, 1, len(report.Mismatches))
+	assert.Equal(t, HELLO+1, report.Mismatches[0].Request.Code)
+	assert.Equal(t, "2", string(report.Mismatches[0].Actual.Body))
+
+	startTime := time.Now()
+	report = Replay(client, address, records, &ReplayOptions{Speed: 100})
+	assert.Equal(t, 2, report.Matched)
+	assert.True(t, time.Since(startTime) < time.Second)
+}
@@ -2,21 +2,124 @@ package protocol
 
 import (
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sync"
 	"time"
 )
 
 type TenuredClient struct {
 	tenuredService
 	*AuthHeader
+
+	//收到GOAWAY的服务端地址，重新连接认证成功后删除
+	goawayLock sync.RWMutex
+	goaways    map[string]bool
+
+	//集群密钥，不为空时使用此密钥签名认证信息
+	Secret string
+
+	//心跳往返时间(EWMA)超过MaxRTT，或者连续丢失MaxMissed个心跳的地址不可用，0不限制
+	MaxRTT    time.Duration
+	MaxMissed int
+
+	//调用拦截器
+	clientInterceptors []ClientInterceptor
+
+	//和服务端协商的header编码
+	headerCodecLock sync.RWMutex
+	headerCodecs    map[string]*HeaderCodec
+}
+
+//注册调用拦截器，按照注册顺序执行，需要在Start之前注册
+func (this *TenuredClient) Use(interceptors ...ClientInterceptor) {
+	this.clientInterceptors = append(this.clientInterceptors, interceptors...)
+}
+
+func (this *TenuredClient) Invoke(address string, command *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
+	if len(this.clientInterceptors) == 0 {
+		return this.tenuredService.Invoke(address, command, timeout)
+	}
+	return chainClient(this.clientInterceptors, this.tenuredService.Invoke)(address, command, timeout)
+}
+
+//有拦截器时在新的协程中同步调用
+func (this *TenuredClient) AsyncInvoke(address string, command *TenuredCommand, timeout time.Duration,
+	callback func(tenuredCommand *TenuredCommand, err error)) {
+	if len(this.clientInterceptors) == 0 {
+		this.tenuredService.AsyncInvoke(address, command, timeout, callback)
+		return
+	}
+	go func() {
+		callback(this.Invoke(address, command, timeout))
+	}()
+}
+
+//单向请求同样经过拦截器，拦截器中next返回的响应为nil
+func (this *TenuredClient) InvokeOneway(address string, command *TenuredCommand, timeout time.Duration) error {
+	command.MakeOneway()
+	if len(this.clientInterceptors) == 0 {
+		return this.tenuredService.InvokeOneway(address, command, timeout)
+	}
+	_, err := chainClient(this.clientInterceptors, func(address string, request *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
+		return nil, this.tenuredService.InvokeOneway(address, request, timeout)
+	})(address, command, timeout)
+	return err
+}
+
+func (this *TenuredClient) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
+	if command := msg.(*TenuredCommand); !command.IsACK() && command.code == REQUEST_CODE_GOAWAY {
+		logger.Infof("server %s is going away", channel.RemoteAddr())
+		this.goawayLock.Lock()
+		this.goaways[channel.RemoteAddr()] = true
+		this.goawayLock.Unlock()
+		return
+	}
+	this.tenuredService.OnMessage(channel, msg)
+}
+
+//服务地址是否可用：没有收到服务端的GOAWAY，不在后台重连中，并且心跳统计没有超过阈值
+func (this *TenuredClient) IsAvailable(address string) bool {
+	this.goawayLock.RLock()
+	goaway := this.goaways[address]
+	this.goawayLock.RUnlock()
+	if goaway {
+		return false
+	}
+	if this.MaxRTT > 0 || this.MaxMissed > 0 {
+		if stats, has := this.Health(address); has &&
+			((this.MaxRTT > 0 && stats.EWMA > this.MaxRTT) || (this.MaxMissed > 0 && stats.Missed >= this.MaxMissed)) {
+			return false
+		}
+	}
+	if client, match := this.remoting.(*remoting.RemotingClient); match {
+		if state, known := client.State(address); known && state != remoting.STATE_CONNECTED {
+			return false
+		}
+	}
+	return true
 }
 
 func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 	logger.Debug("send auth code:", channel.RemoteAddr())
+	authHeader := this.AuthHeader.clone()
+	coder := coderOf(channel)
+	if coder != nil && coder.config.Compress != "" {
+		authHeader.Attributes[AUTH_ATTRIBUTE_COMPRESS] = coder.config.Compress
+	}
+	if coder != nil && coder.config.HeaderCodec != "" {
+		authHeader.Attributes[AUTH_ATTRIBUTE_HEADER_CODEC] = coder.config.HeaderCodec
+	}
+	if coder != nil {
+		authHeader.Attributes[AUTH_ATTRIBUTE_DEADLINE] = "true"
+	}
+	if this.Secret != "" {
+		authHeader.sign(this.Secret)
+	}
 	request := NewRequest(REQUEST_CODE_ATUH)
-	if err := request.SetHeader(this.AuthHeader); err != nil {
+	if err := request.SetHeader(authHeader); err != nil {
 		return err
 	}
-	resp, err := this.Invoke(channel.RemoteAddr(), request, time.Second*3)
+	//连接池中每个channel都需要认证，所以直接在channel上发送认证请求
+	resp, err := this.invokeChannel(channel, request, time.Second*3)
 	if err != nil {
 		logger.Debug("send auth error:", err)
 		return err
@@ -26,6 +129,10 @@ func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 		return err
 	}
 
+	this.goawayLock.Lock()
+	delete(this.goaways, channel.RemoteAddr())
+	this.goawayLock.Unlock()
+
 	header := &AuthHeader{}
 	if err := resp.GetHeader(header); err != nil {
 		logger.Warning("Cannot get the information returned by the server: ", err.Error())
@@ -33,9 +140,35 @@ func (this *TenuredClient) OnChannel(channel remoting.RemotingChannel) error {
 	} else {
 		logger.Info("Get the information returned by the server:", header)
 	}
+	if coder != nil && header.Attributes != nil {
+		if compressor := negotiateCompress(coder.config.Compress, header.Attributes[AUTH_ATTRIBUTE_COMPRESS]); compressor != nil {
+			logger.Debugf("channel(%s) use compressor %s", channel.RemoteAddr(), compressor.Name)
+			coder.setCompressor(compressor)
+		}
+		headerCodec := negotiateHeaderCodec(coder.config.HeaderCodec, header.Attributes[AUTH_ATTRIBUTE_HEADER_CODEC])
+		if headerCodec != nil {
+			logger.Debugf("channel(%s) use header codec %s", channel.RemoteAddr(), headerCodec.Name)
+		}
+		this.headerCodecLock.Lock()
+		this.headerCodecs[channel.RemoteAddr()] = headerCodec
+		this.headerCodecLock.Unlock()
+		coder.setDeadline(header.Attributes[AUTH_ATTRIBUTE_DEADLINE] == "true")
+	}
 	return nil
 }
 
+//和服务端协商的header编码，未协商时返回nil(使用json)
+func (this *TenuredClient) HeaderCodec(address string) *HeaderCodec {
+	this.headerCodecLock.RLock()
+	defer this.headerCodecLock.RUnlock()
+	return this.headerCodecs[address]
+}
+
+//订阅服务地址的连接状态变化，连接断开后客户端会在后台自动重连
+func (this *TenuredClient) Subscribe(address string, listener remoting.StateListener) func() {
+	return this.remoting.(*remoting.RemotingClient).Subscribe(address, listener)
+}
+
 func (this *TenuredClient) Start() error {
 	if this.AuthHeader == nil {
 		return ErrorNoModule()
@@ -48,13 +181,15 @@ func NewTenuredClient(config *remoting.RemotingConfig) (*TenuredClient, error) {
 		config = remoting.DefaultConfig()
 	}
 	remotingClient := remoting.NewRemotingClient(config)
-	remotingClient.SetCoder(&tenuredCoder{config: config})
+	remotingClient.SetCoderFactory(tenuredCoderFactory)
 	client := &TenuredClient{
 		tenuredService: tenuredService{
 			remoting:         remotingClient,
-			responseTables:   map[uint32]*responseTableBlock{},
+			responseTables:   newResponseTable(),
 			commandProcesser: map[uint16]*tenuredCommandRunner{},
 		},
+		goaways:      map[string]bool{},
+		headerCodecs: map[string]*HeaderCodec{},
 	}
 	remotingClient.SetHandler(client)
 	return client, nil
@@ -3,61 +3,328 @@ package protocol
 import (
 	"github.com/ihaiker/tenured-go-server/commons/registry"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sync"
 	"time"
 )
 
+//服务客户端配置
+type ClientConfig struct {
+	//客户端模块名称和集群密钥，认证时使用密钥签名
+	Module string `json:"module,omitempty" yaml:"module,omitempty"`
+	Secret string `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	//为空时使用默认配置，并且header优先使用msgpack编码
+	Remoting *remoting.RemotingConfig `json:"remoting,omitempty" yaml:"remoting,omitempty"`
+}
+
+func DefaultClientConfig() *ClientConfig {
+	config := remoting.DefaultConfig()
+	config.HeaderCodec = DEFAULT_HEADER_CODECS
+	return &ClientConfig{Remoting: config}
+}
+
 type TenuredClientInvoke struct {
 	client *TenuredClient
+	config *remoting.RemotingConfig
+
+	//客户端身份，认证时发送给服务端
+	module string
+	secret string
+
+	//心跳统计超过阈值的实例不可用
+	maxRTT    time.Duration
+	maxMissed int
+
+	//幂等方法的重试策略
+	retryPolicy *RetryPolicy
+
+	//按实例熔断，为nil时不熔断
+	breaker *CircuitBreaker
+
+	//订阅服务实例变化，实例注销时清理熔断统计
+	serverName string
+	reg        registry.ServiceRegistry
+
+	//只读方法的对冲策略和按请求码统计的响应时间
+	hedgePolicy *HedgePolicy
+	latencyLock sync.Mutex
+	latencies   map[uint16]*latencySamples
+}
+
+//设置客户端的模块名称和集群密钥，需要在Start之前设置
+func (this *TenuredClientInvoke) SetIdentity(module, secret string) {
+	this.module, this.secret = module, secret
 }
 
 func (this *TenuredClientInvoke) Invoke(
 	serverInstance *registry.ServerInstance,
 	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
 ) ([]byte, *TenuredError) {
-	request := NewRequest(code)
+	respBody, err := this.invoke(serverInstance, code, header, body, timeout, respHeader)
+	if err != nil {
+		return nil, ConvertError(err)
+	}
+	return respBody, nil
+}
+
+//返回原始的错误，重试时用来判断错误类型
+func (this *TenuredClientInvoke) invoke(
+	serverInstance *registry.ServerInstance,
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, error) {
+	request, err := this.newRequest(serverInstance, code, header, body)
+	if err != nil {
+		return nil, err
+	}
+	response, err := this.call(serverInstance, request, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResponse(response, respHeader)
+}
+
+func (this *TenuredClientInvoke) newRequest(
+	serverInstance *registry.ServerInstance, code uint16, header interface{}, body []byte,
+) (*TenuredCommand, error) {
+	request := NewRequest(code).SetHeaderCodec(this.client.HeaderCodec(serverInstance.Address))
 	if header != nil {
 		if err := request.SetHeader(header); err != nil {
-			return nil, ConvertError(err)
+			return nil, err
 		}
 	}
 	if body != nil {
 		request.Body = body
 	}
+	return request, nil
+}
+
+//发送请求并记录熔断统计，服务端返回的错误作为error返回
+func (this *TenuredClientInvoke) call(
+	serverInstance *registry.ServerInstance, request *TenuredCommand, timeout time.Duration,
+) (*TenuredCommand, error) {
+	if this.breaker != nil && !this.breaker.Acquire(serverInstance.Address) {
+		return nil, ErrorCircuitOpen(serverInstance.Address)
+	}
+	startTime := time.Now()
 	response, invokeErr := this.client.Invoke(serverInstance.Address, request, timeout)
+	if this.breaker != nil {
+		//服务端返回的错误说明实例可以正常处理请求，主动取消的请求(对冲请求的失败方)不能说明实例是否正常
+		if IsCanceled(invokeErr) {
+			this.breaker.Release(serverInstance.Address)
+		} else {
+			this.breaker.Done(serverInstance.Address, invokeErr != nil, time.Since(startTime))
+		}
+	}
 	if invokeErr != nil {
-		return nil, ConvertError(invokeErr)
+		return nil, invokeErr
 	}
 	if !response.IsSuccess() {
 		return nil, response.GetError()
 	}
+	return response, nil
+}
+
+func decodeResponse(response *TenuredCommand, respHeader interface{}) ([]byte, error) {
 	if respHeader != nil {
 		if err := response.GetHeader(respHeader); err != nil {
-			return nil, ConvertError(err)
+			return nil, err
 		}
 	}
 	return response.Body, nil
 }
 
+//设置幂等方法的重试策略，为nil时不重试
+func (this *TenuredClientInvoke) SetRetryPolicy(policy *RetryPolicy) {
+	this.retryPolicy = policy
+}
+
+//幂等方法的调用，失败时按照重试策略选择其他实例重试，params为负载均衡选择实例的参数
+func (this *TenuredClientInvoke) InvokeRetry(
+	loadBalance registry.LoadBalance, params []interface{},
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, *TenuredError) {
+	return this.retry(loadBalance, params, code, timeout,
+		func(serverInstances []*registry.ServerInstance, tried map[string]bool, timeout time.Duration) ([]byte, error) {
+			selected := untried(serverInstances, tried)
+			tried[selected.Address] = true
+			return this.invoke(selected, code, header, body, timeout, respHeader)
+		})
+}
+
+//按照重试策略调用attempt，每次调用前重新选择实例，tried为已经调用过的实例地址。
+//所有调用共用timeout，每次调用只使用剩余的时间，剩余时间不够等待重试间隔时不再重试
+func (this *TenuredClientInvoke) retry(
+	loadBalance registry.LoadBalance, params []interface{}, code uint16, timeout time.Duration,
+	attempt func(serverInstances []*registry.ServerInstance, tried map[string]bool, timeout time.Duration) ([]byte, error),
+) ([]byte, *TenuredError) {
+	maxAttempts := 1
+	if this.retryPolicy != nil && this.retryPolicy.MaxAttempts > 1 {
+		maxAttempts = this.retryPolicy.MaxAttempts
+	}
+	tried := map[string]bool{}
+	deadline := time.Now().Add(timeout)
+	var err error
+	for i := 0; i < maxAttempts; i++ {
+		if i > 0 {
+			if !this.retryPolicy.Retryable(err) {
+				break
+			}
+			backoff := this.retryPolicy.backoff(i)
+			if time.Until(deadline) <= backoff {
+				break
+			}
+			time.Sleep(backoff)
+		}
+		serverInstance, regKey, selectErr := loadBalance.Select(params...)
+		if selectErr != nil || len(serverInstance) == 0 || registry.AllNotOK(serverInstance...) {
+			err = ErrorRouter()
+			continue
+		}
+		var respBody []byte
+		respBody, err = attempt(serverInstance, tried, time.Until(deadline))
+		loadBalance.Return(regKey)
+		if err == nil {
+			return respBody, nil
+		}
+		if i+1 < maxAttempts {
+			logger.Debugf("invoke %d error: %s", code, err)
+		}
+	}
+	return nil, ConvertError(err)
+}
+
+//优先选择没有调用过的可用实例
+func untried(serverInstances []*registry.ServerInstance, tried map[string]bool) *registry.ServerInstance {
+	var selected *registry.ServerInstance
+	for _, serverInstance := range serverInstances {
+		if !registry.IsOK(serverInstance) {
+			continue
+		}
+		if !tried[serverInstance.Address] {
+			return serverInstance
+		} else if selected == nil {
+			selected = serverInstance
+		}
+	}
+	return selected
+}
+
+//单向调用，服务端不返回响应
+func (this *TenuredClientInvoke) InvokeOneway(
+	serverInstance *registry.ServerInstance,
+	code uint16, header interface{}, body []byte, timeout time.Duration,
+) *TenuredError {
+	request, err := this.newRequest(serverInstance, code, header, body)
+	if err != nil {
+		return ConvertError(err)
+	}
+	if err := this.client.InvokeOneway(serverInstance.Address, request, timeout); err != nil {
+		return ConvertError(err)
+	}
+	return nil
+}
+
+//设置心跳往返时间和连续丢失心跳数的阈值，超过的实例不再被负载均衡选中，需要在Start之前设置
+func (this *TenuredClientInvoke) SetHealthThreshold(maxRTT time.Duration, maxMissed int) {
+	this.maxRTT, this.maxMissed = maxRTT, maxMissed
+}
+
+//服务实例的心跳统计
+func (this *TenuredClientInvoke) Health(serverInstance *registry.ServerInstance) (HealthStats, bool) {
+	if this.client == nil {
+		return HealthStats{}, false
+	}
+	return this.client.Health(serverInstance.Address)
+}
+
+//设置熔断配置，为nil时关闭熔断，需要在Start之前设置
+func (this *TenuredClientInvoke) SetCircuitBreaker(config *BreakerConfig) {
+	if config == nil {
+		this.breaker = nil
+	} else {
+		this.breaker = NewCircuitBreaker(config)
+	}
+}
+
+func (this *TenuredClientInvoke) CircuitBreaker() *CircuitBreaker {
+	return this.breaker
+}
+
+//服务实例的熔断状态
+func (this *TenuredClientInvoke) BreakerState(serverInstance *registry.ServerInstance) BreakerState {
+	if this.breaker == nil {
+		return BREAKER_CLOSED
+	}
+	return this.breaker.State(serverInstance.Address)
+}
+
+//服务实例是否可用，注册到负载均衡中过滤不可用的实例
+func (this *TenuredClientInvoke) IsAvailable(serverInstance *registry.ServerInstance) bool {
+	if this.breaker != nil && !this.breaker.Available(serverInstance.Address) {
+		return false
+	}
+	if this.client == nil {
+		return true
+	}
+	return this.client.IsAvailable(serverInstance.Address)
+}
+
 func (this *TenuredClientInvoke) initTenuredClient() (err error) {
-	if this.client, err = NewTenuredClient(remoting.DefaultConfig()); err != nil {
+	if this.client, err = NewTenuredClient(this.config); err != nil {
 		return
 	}
-	this.client.AuthHeader = &AuthHeader{}
+	this.client.AuthHeader = &AuthHeader{Module: this.module, Attributes: map[string]string{}}
+	this.client.Secret = this.secret
+	this.client.MaxRTT, this.client.MaxMissed = this.maxRTT, this.maxMissed
 	return this.client.Start()
 }
 
+//订阅serverName的实例变化，需要在Start之前设置
+func (this *TenuredClientInvoke) Watch(serverName string, reg registry.ServiceRegistry) {
+	this.serverName, this.reg = serverName, reg
+}
+
+//实例注销后地址不会再被调用，删除它的熔断统计
+func (this *TenuredClientInvoke) onNotify(status registry.RegistionStatus, serverInstances []*registry.ServerInstance) {
+	if status != registry.UNREGISTER || this.breaker == nil {
+		return
+	}
+	for _, serverInstance := range serverInstances {
+		this.breaker.Remove(serverInstance.Address)
+	}
+}
+
 func (this *TenuredClientInvoke) Start() (err error) {
 	if err = this.initTenuredClient(); err != nil {
 		return
 	}
+	if this.reg != nil {
+		return this.reg.Subscribe(this.serverName, this.onNotify)
+	}
 	return nil
 }
 
 func (this *TenuredClientInvoke) Shutdown(interrupt bool) {
+	if this.reg != nil {
+		_ = this.reg.Unsubscribe(this.serverName, this.onNotify)
+	}
 	this.client.Shutdown(interrupt)
 }
 
-func NewClientInvoke() *TenuredClientInvoke {
-	serverClient := &TenuredClientInvoke{}
+func NewClientInvoke(config *ClientConfig) *TenuredClientInvoke {
+	defaultConfig := DefaultClientConfig()
+	if config == nil {
+		config = defaultConfig
+	}
+	serverClient := &TenuredClientInvoke{
+		module:      config.Module,
+		secret:      config.Secret,
+		config:      config.Remoting,
+		breaker:     NewCircuitBreaker(DefaultBreakerConfig()),
+		hedgePolicy: DefaultHedgePolicy(),
+	}
+	if serverClient.config == nil {
+		serverClient.config = defaultConfig.Remoting
+	}
 	return serverClient
 }
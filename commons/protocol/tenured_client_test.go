@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countAuthChecker struct {
+	ModuleAuthChecker
+	count int32
+}
+
+func (this *countAuthChecker) Auth(channel remoting.RemotingChannel, command *TenuredCommand) error {
+	atomic.AddInt32(&this.count, 1)
+	return this.ModuleAuthChecker.Auth(channel, command)
+}
+
+func TestTenuredClient_Pool(t *testing.T) {
+	address := "pipe://tenured-pool"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	checker := &countAuthChecker{}
+	server.AuthChecker = checker
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	config := remoting.DefaultConfig()
+	config.PoolSize = 3
+	client, err := NewTenuredClient(config)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	request := NewRequest(HELLO)
+	response, err := client.Invoke(address, request, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, response.IsSuccess())
+
+	//连接池中每个channel都完成认证
+	for i := 0; i < 100 && atomic.LoadInt32(&checker.count) < 3; i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&checker.count))
+
+	for i := 0; i < 30; i++ {
+		response, err := client.Invoke(address, NewRequest(HELLO), time.Second*3)
+		assert.Nil(t, err)
+		assert.True(t, response.IsSuccess())
+	}
+}
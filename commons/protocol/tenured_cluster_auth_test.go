@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type authTestChannel struct {
+	remoting.RemotingChannel
+	attrs map[string]interface{}
+}
+
+func (this *authTestChannel) RemoteAddr() string {
+	return "127.0.0.1:6080"
+}
+
+func (this *authTestChannel) Attributes() map[string]interface{} {
+	return this.attrs
+}
+
+func TestClusterAuthChecker_Replay(t *testing.T) {
+	checker := &ClusterAuthChecker{Secret: "secret"}
+	header := &AuthHeader{Module: "tenured_console", Attributes: map[string]string{}}
+	header.sign("secret")
+	command := NewRequest(REQUEST_CODE_ATUH)
+	assert.Nil(t, command.SetHeader(header))
+
+	channel := &authTestChannel{attrs: map[string]interface{}{}}
+	assert.Nil(t, checker.Auth(channel, command))
+	assert.True(t, checker.IsAuthed(channel))
+	assert.Equal(t, "tenured_console", channel.attrs[AUTH_ATTRIBUTE_MODULE])
+
+	//相同的签名不能重复使用
+	assert.NotNil(t, checker.Auth(&authTestChannel{attrs: map[string]interface{}{}}, command))
+
+	//签名时间超出误差
+	header.sign("secret")
+	header.Attributes[AUTH_ATTRIBUTE_TIMESTAMP] = "1560000000"
+	assert.Nil(t, command.SetHeader(header))
+	assert.NotNil(t, checker.Auth(&authTestChannel{attrs: map[string]interface{}{}}, command))
+}
+
+func TestTenured_ClusterAuth(t *testing.T) {
+	address := "pipe://tenured-cluster-auth"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "tenured_store", Address: address, Attributes: map[string]string{}}
+	server.AuthChecker = &ClusterAuthChecker{
+		Secret:       "secret",
+		AllowModules: []string{"tenured_console", "tenured_linker"},
+	}
+	authorization, err := NewAuthorization(&AuthorizationConfig{
+		DefaultAllow: true,
+		Policies:     []*AuthorizationPolicy{{Command: "3", Modules: []string{"tenured_console"}}},
+	})
+	assert.Nil(t, err)
+	server.Use(authorization.Interceptor())
+	for _, code := range []uint16{HELLO, HELLO + 1} {
+		server.RegisterCommandProcesser(code, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			_ = channel.Write(NewResponse(command), time.Second)
+		}, nil)
+	}
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	invoke := func(module, secret string, code uint16) (*TenuredCommand, error) {
+		client, err := NewTenuredClient(nil)
+		assert.Nil(t, err)
+		client.AuthHeader = &AuthHeader{Module: module, Attributes: map[string]string{}}
+		client.Secret = secret
+		assert.Nil(t, client.Start())
+		defer client.Shutdown(true)
+		return client.Invoke(address, NewRequest(code), time.Second)
+	}
+
+	response, err := invoke("tenured_console", "secret", HELLO+1)
+	assert.Nil(t, err)
+	assert.True(t, response.IsSuccess())
+
+	response, err = invoke("tenured_linker", "secret", HELLO)
+	assert.Nil(t, err)
+	assert.True(t, response.IsSuccess())
+
+	//只有控制台可以调用
+	response, err = invoke("tenured_linker", "secret", HELLO+1)
+	assert.Nil(t, err)
+	assert.Equal(t, "1007", response.GetError().Code())
+
+	//密钥错误、模块不在允许列表中、未签名
+	for _, identity := range [][]string{{"tenured_console", "wrong"}, {"tenured_other", "secret"}, {"tenured_console", ""}} {
+		_, err = invoke(identity[0], identity[1], HELLO)
+		assert.NotNil(t, err, identity[0])
+	}
+
+	//生成的客户端使用配置中的身份签名
+	clientInvoke := NewClientInvoke(&ClientConfig{Module: "tenured_console", Secret: "secret"})
+	assert.Nil(t, clientInvoke.Start())
+	defer clientInvoke.Shutdown(true)
+	_, tenuredErr := clientInvoke.Invoke(&registry.ServerInstance{Address: address}, HELLO+1, nil, nil, time.Second, nil)
+	assert.Nil(t, tenuredErr)
+}
+
+func TestSignVerifier(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+	verifier := &SignVerifier{MaxSkew: time.Minute}
+	sign := HmacSign("secret", "1001", now.Unix(), "n1")
+	assert.Nil(t, verifier.Verify("secret", "1001", now.Unix(), "n1", sign, now))
+	assert.NotNil(t, verifier.Verify("secret", "1001", now.Unix(), "n1", sign, now))
+	assert.NotNil(t, verifier.Verify("wrong", "1001", now.Unix(), "n2", sign, now))
+	assert.NotNil(t, verifier.Verify("secret", "1001", now.Unix(), "", HmacSign("secret", "1001", now.Unix(), ""), now))
+
+	//误差时间后签名失效，过期的nonce被清理
+	later := now.Add(time.Minute * 2)
+	assert.NotNil(t, verifier.Verify("secret", "1001", now.Unix(), "n1", sign, later))
+	assert.Nil(t, verifier.Verify("secret", "1001", later.Unix(), "n1", HmacSign("secret", "1001", later.Unix(), "n1"), later))
+	assert.Equal(t, 1, len(verifier.nonces))
+}
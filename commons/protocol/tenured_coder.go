@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -8,43 +9,172 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 const lengthMin = 4 /*length*/ + 4 /*id*/ + 2 /*code*/ + 1 /*version*/ + 3 /*(header.length < 2) | flag*/
 var endian = binary.BigEndian
 
+//version/flag中预留位，第22位标识header和body经过压缩：[1字节压缩算法][4字节body原始长度][压缩后的header|body]
+const vfCompressed = uint32(1) << 22
+
+//version/flag中预留位，第23位标识固定头部之后有4字节的请求剩余等待时间(毫秒)
+const vfTimeout = uint32(1) << 23
+
+//请求剩余等待时间长度
+const timeoutMin = 4
+
+//认证时协商是否发送请求剩余等待时间，旧版本不认识vfTimeout，对端确认后才能发送
+const AUTH_ATTRIBUTE_DEADLINE = "deadline"
+
+//压缩帧头部长度
+const compressedMin = 1 /*compressor*/ + 4 /*body.length*/
+
+//解压后长度不能超过PacketBytesLimit的倍数，防止压缩炸弹
+const maxCompressRatio = 64
+
+//分片帧body头部长度：[4字节原帧长度]
+const fragmentMin = 4
+
+const coder_attributes_name = "tenured_coder"
+
 type tenuredCoder struct {
 	config *remoting.RemotingConfig
+
+	//协商后的压缩算法ID，0不压缩
+	compress uint32
+
+	//对端确认支持请求剩余等待时间后为1
+	deadline uint32
+
+	//未接收完整的分片消息，key: id<<1|ack
+	partialLock sync.Mutex
+	partials    map[uint64]*partialMessage
+
+	//服务端抓包，客户端为nil
+	capture *captureSwitch
+}
+
+type partialMessage struct {
+	total int
+	data  []byte
+}
+
+//每个channel使用单独的coder，保存协商的压缩算法
+func tenuredCoderFactory(channel remoting.RemotingChannel, config remoting.RemotingConfig) remoting.RemotingCoder {
+	coder := &tenuredCoder{config: &config}
+	if channel != nil && channel.Attributes() != nil {
+		channel.Attributes()[coder_attributes_name] = coder
+	}
+	return coder
+}
+
+func coderOf(channel remoting.RemotingChannel) *tenuredCoder {
+	if channel == nil || channel.Attributes() == nil {
+		return nil
+	}
+	coder, _ := channel.Attributes()[coder_attributes_name].(*tenuredCoder)
+	return coder
+}
+
+func (this *tenuredCoder) setCompressor(compressor *Compressor) {
+	if compressor == nil {
+		atomic.StoreUint32(&this.compress, 0)
+	} else {
+		atomic.StoreUint32(&this.compress, uint32(compressor.Id))
+	}
+}
+
+func (this *tenuredCoder) setDeadline(enable bool) {
+	if enable {
+		atomic.StoreUint32(&this.deadline, 1)
+	} else {
+		atomic.StoreUint32(&this.deadline, 0)
+	}
+}
+
+//编码时写入的请求剩余等待时间，对端未确认支持和认证请求不写入
+func (this *tenuredCoder) timeoutOf(msg *TenuredCommand) uint32 {
+	if msg.code == REQUEST_CODE_ATUH || atomic.LoadUint32(&this.deadline) == 0 {
+		return 0
+	}
+	return msg.timeout
+}
+
+func (this *tenuredCoder) compressor() *Compressor {
+	if id := atomic.LoadUint32(&this.compress); id != 0 {
+		return compressors[uint8(id)]
+	}
+	return nil
 }
 
 func (this *tenuredCoder) Decode(channel remoting.RemotingChannel, reader io.Reader) (interface{}, error) {
-	command := &TenuredCommand{}
-	length := uint32(0)
-	//length
-	if err := binary.Read(reader, endian, &length); err != nil {
+	command, err := this.decode(reader, this.config.PacketBytesLimit)
+	if err != nil {
 		return nil, err
-	} else if length < uint32(lengthMin) || length >= uint32(this.config.PacketBytesLimit) {
-		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("head length %d", length))}
+	} else if command.code == REQUEST_CODE_FRAGMENT {
+		msg, err := this.reassemble(command)
+		if reassembled, match := msg.(*TenuredCommand); match && reassembled != nil && this.capture != nil {
+			this.capture.record(channel, CAPTURE_IN, reassembled)
+		}
+		return msg, err
 	}
-	//id
-	if err := binary.Read(reader, endian, &command.id); err != nil {
-		return nil, err
+	if this.capture != nil {
+		this.capture.record(channel, CAPTURE_IN, command)
 	}
-	//code
-	if err := binary.Read(reader, endian, &command.code); err != nil {
+	return command, nil
+}
+
+//读取n个字节，reader为BufferedReader时直接引用读取缓冲区，不再分配内存，数据只在本次Decode中有效
+func readBytes(reader io.Reader, n int) ([]byte, error) {
+	if buffered, match := reader.(*remoting.BufferedReader); match {
+		return buffered.Next(n)
+	}
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(reader, bs); err != nil {
 		return nil, err
 	}
-	vf := uint32(0)
-	if err := binary.Read(reader, endian, &vf); err != nil {
+	return bs, nil
+}
+
+func (this *tenuredCoder) decode(reader io.Reader, limit int) (*TenuredCommand, error) {
+	command := &TenuredCommand{}
+	//length(4) id(4) code(2) version/flag(4)
+	head, err := readBytes(reader, lengthMin)
+	if err != nil {
 		return nil, err
 	}
+	length := endian.Uint32(head)
+	if length < uint32(lengthMin) || length > uint32(limit) {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("head length %d", length))}
+	}
+	command.id = endian.Uint32(head[4:])
+	command.code = endian.Uint16(head[8:])
+	vf := endian.Uint32(head[10:])
 
 	command.Version = uint8((vf >> 24) & 0xFF)
 	command.flag = int(vf & 3 /*0b11*/)
 	headerLength := int((vf & 0x3FFFFF) >> 2)
+	if vf&vfTimeout != 0 {
+		if length < uint32(lengthMin+timeoutMin) {
+			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("head length %d", length))}
+		}
+		bs, err := readBytes(reader, timeoutMin)
+		if err != nil {
+			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
+		}
+		command.timeout = endian.Uint32(bs)
+		length -= timeoutMin
+	}
+	if vf&vfCompressed != 0 {
+		return this.decodeCompressed(reader, command, int(length), headerLength, limit)
+	} else if headerLength > int(length)-lengthMin {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("header length %d", headerLength))}
+	}
 	if headerLength > 0 {
 		command.header = make([]byte, headerLength, headerLength)
-		if i, err := reader.Read(command.header); err != nil {
+		if i, err := io.ReadFull(reader, command.header); err != nil {
 			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
 		} else if i != headerLength {
 			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
@@ -54,7 +184,7 @@ func (this *tenuredCoder) Decode(channel remoting.RemotingChannel, reader io.Rea
 	bodyLength := int(length) - lengthMin - headerLength
 	if bodyLength > 0 {
 		command.Body = make([]byte, bodyLength)
-		if i, err := reader.Read(command.Body); err != nil {
+		if i, err := io.ReadFull(reader, command.Body); err != nil {
 			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
 		} else if i != bodyLength {
 			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
@@ -64,14 +194,140 @@ func (this *tenuredCoder) Decode(channel remoting.RemotingChannel, reader io.Rea
 	return command, nil
 }
 
+func (this *tenuredCoder) decodeCompressed(reader io.Reader, command *TenuredCommand, length, headerLength, limit int) (*TenuredCommand, error) {
+	if length < lengthMin+compressedMin {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("compressed length %d", length))}
+	}
+	bs, err := readBytes(reader, length-lengthMin)
+	if err != nil {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
+	}
+	compressor, has := compressors[bs[0]]
+	if !has {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("not support compressor %d", bs[0]))}
+	}
+	size := headerLength + int(endian.Uint32(bs[1:]))
+	if size > limit*maxCompressRatio {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New(fmt.Sprintf("decompressed length %d", size))}
+	}
+	data, err := compressor.Decompress(bs[compressedMin:], size)
+	if err != nil {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: err}
+	}
+	if headerLength > 0 {
+		command.header = data[:headerLength]
+	}
+	if size > headerLength {
+		command.Body = data[headerLength:]
+	}
+	return command, nil
+}
+
+//收到分片帧，全部接收后解码原消息，未接收完整返回nil
+func (this *tenuredCoder) reassemble(fragment *TenuredCommand) (interface{}, error) {
+	if len(fragment.Body) <= fragmentMin {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New("invalid fragment")}
+	}
+	total := int(endian.Uint32(fragment.Body))
+	chunk := fragment.Body[fragmentMin:]
+	key := uint64(fragment.id)<<1 | uint64((fragment.flag&FLAG_ACK)>>1)
+
+	this.partialLock.Lock()
+	partial, has := this.partials[key]
+	if !has {
+		if this.config.MaxMessageBytes <= 0 || total > this.config.MaxMessageBytes || total < lengthMin {
+			this.partialLock.Unlock()
+			return nil, &remoting.RemotingError{Op: remoting.ErrPacketBytesLimit,
+				Err: errors.New(fmt.Sprintf("fragment message length %d", total))}
+		}
+		if this.config.MaxPartialMessages > 0 && len(this.partials) >= this.config.MaxPartialMessages {
+			this.partialLock.Unlock()
+			return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
+				Err: errors.New(fmt.Sprintf("too many partial messages %d", len(this.partials)))}
+		}
+		if this.partials == nil {
+			this.partials = map[uint64]*partialMessage{}
+		}
+		partial = &partialMessage{total: total, data: make([]byte, 0, total)}
+		this.partials[key] = partial
+	}
+	if partial.total != total || len(partial.data)+len(chunk) > total {
+		delete(this.partials, key)
+		this.partialLock.Unlock()
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder,
+			Err: errors.New(fmt.Sprintf("fragment %d length error", fragment.id))}
+	}
+	partial.data = append(partial.data, chunk...)
+	if len(partial.data) < total {
+		this.partialLock.Unlock()
+		return nil, nil
+	}
+	delete(this.partials, key)
+	this.partialLock.Unlock()
+
+	command, err := this.decode(bytes.NewReader(partial.data), total)
+	if err != nil {
+		return nil, err
+	} else if command.code == REQUEST_CODE_FRAGMENT {
+		return nil, &remoting.RemotingError{Op: remoting.ErrDecoder, Err: errors.New("nested fragment")}
+	}
+	return command, nil
+}
+
+//channel关闭时丢弃未接收完整的分片
+func (this *tenuredCoder) OnClose(channel remoting.RemotingChannel) {
+	this.partialLock.Lock()
+	defer this.partialLock.Unlock()
+	this.partials = nil
+}
+
+//编码后超过PacketBytesLimit的消息由coder分片，channel不再检查消息长度
+func (this *tenuredCoder) Fragmented() bool {
+	return this.config.MaxMessageBytes > 0
+}
+
 func (this *tenuredCoder) Encode(channel remoting.RemotingChannel, msg interface{}) ([]byte, error) {
-	if bs, ok := msg.(*TenuredCommand); ok {
-		return this.encodeCommand(bs)
+	if command, ok := msg.(*TenuredCommand); ok {
+		if this.capture != nil {
+			this.capture.record(channel, CAPTURE_OUT, command)
+		}
+		bs, err := this.encodeCommand(command)
+		if err != nil || len(bs) <= this.config.PacketBytesLimit {
+			return bs, err
+		}
+		if this.config.MaxMessageBytes <= 0 || len(bs) > this.config.MaxMessageBytes ||
+			this.config.PacketBytesLimit <= lengthMin+fragmentMin {
+			return nil, &remoting.RemotingError{Op: remoting.ErrPacketBytesLimit,
+				Err: errors.New("the packet limit size " + strconv.Itoa(this.config.PacketBytesLimit))}
+		}
+		return this.fragment(command, bs), nil
 	} else {
 		return nil, os.ErrInvalid
 	}
 }
 
+//把帧拆分为多个分片帧，分片帧的id和flag与原消息相同，code为REQUEST_CODE_FRAGMENT，body为[4字节原帧长度][原帧片段]
+func (this *tenuredCoder) fragment(command *TenuredCommand, frame []byte) []byte {
+	chunkSize := this.config.PacketBytesLimit - lengthMin - fragmentMin
+	count := (len(frame) + chunkSize - 1) / chunkSize
+	out := make([]byte, 0, len(frame)+count*(lengthMin+fragmentMin))
+	head := make([]byte, lengthMin+fragmentMin)
+	for offset := 0; offset < len(frame); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(frame) {
+			end = len(frame)
+		}
+		endian.PutUint32(head, uint32(lengthMin+fragmentMin+end-offset))
+		endian.PutUint32(head[4:], command.id)
+		endian.PutUint16(head[8:], REQUEST_CODE_FRAGMENT)
+		endian.PutUint32(head[10:], uint32(command.flag&3 /*0b11*/))
+		endian.PutUint32(head[lengthMin:], uint32(len(frame)))
+		out = append(out, head...)
+		out = append(out, frame[offset:end]...)
+	}
+	return out
+}
+
 func (this *tenuredCoder) encodeCommand(msg *TenuredCommand) ([]byte, error) {
 	length := uint32(lengthMin)
 	headerLength := uint32(0)
@@ -79,29 +335,79 @@ func (this *tenuredCoder) encodeCommand(msg *TenuredCommand) ([]byte, error) {
 	if msg.header != nil && len(msg.header) != 0 {
 		headerLength = uint32(len(msg.header))
 	}
+	if headerLength >= 1<<20 {
+		return nil, &remoting.RemotingError{Op: remoting.ErrEncoder, Err: errors.New(fmt.Sprintf("header length %d", headerLength))}
+	}
 	length += headerLength
 
 	if msg.Body != nil && len(msg.Body) != 0 {
 		length += uint32(len(msg.Body))
 	}
-	if int64(length) > int64(this.config.PacketBytesLimit) {
-		return nil, &remoting.RemotingError{Op: remoting.ErrPacketBytesLimit,
-			Err: errors.New("the packet limit size " + strconv.Itoa(this.config.PacketBytesLimit))}
+	if compressor := this.compressor(); compressor != nil && this.config.CompressThreshold > 0 &&
+		int(length)-lengthMin >= this.config.CompressThreshold {
+		if bs, err := this.encodeCompressed(msg, compressor, headerLength, length); err != nil || bs != nil {
+			return bs, err
+		}
+	}
+	offset := uint32(lengthMin)
+	if this.timeoutOf(msg) > 0 {
+		offset += timeoutMin
+		length += timeoutMin
 	}
-
 	bs := make([]byte, length, length)
 	endian.PutUint32(bs, length)       //4
 	endian.PutUint32(bs[4:], msg.id)   //4
 	endian.PutUint16(bs[8:], msg.code) //2
-
-	vf := (uint32(msg.Version&0xFF) << 24) | uint32((headerLength&0x3FFFFF)<<2) | uint32(msg.flag&3 /*0b11*/)
-	endian.PutUint32(bs[10:], vf)
+	this.putVersionFlag(bs, msg, headerLength, 0)
 
 	if headerLength > 0 {
-		copy(bs[14:], msg.header)
+		copy(bs[offset:], msg.header)
 	}
 	if msg.Body != nil && len(msg.Body) > 0 {
-		copy(bs[14+headerLength:], msg.Body)
+		copy(bs[offset+headerLength:], msg.Body)
 	}
 	return bs, nil
 }
+
+//压缩header和body，压缩后没有变小时返回nil
+func (this *tenuredCoder) encodeCompressed(msg *TenuredCommand, compressor *Compressor, headerLength, length uint32) ([]byte, error) {
+	if headerLength >= 1<<20 || int(length)-lengthMin > this.config.PacketBytesLimit*maxCompressRatio {
+		return nil, nil
+	}
+	data := make([]byte, 0, length-lengthMin)
+	data = append(data, msg.header...)
+	data = append(data, msg.Body...)
+	compressed, err := compressor.Compress(data)
+	if err != nil {
+		return nil, &remoting.RemotingError{Op: remoting.ErrEncoder, Err: err}
+	}
+	compressedLength := uint32(lengthMin + compressedMin + len(compressed))
+	if compressedLength >= length {
+		return nil, nil
+	}
+	offset := uint32(lengthMin)
+	if this.timeoutOf(msg) > 0 {
+		offset += timeoutMin
+		compressedLength += timeoutMin
+	}
+
+	bs := make([]byte, compressedLength)
+	endian.PutUint32(bs, compressedLength)
+	endian.PutUint32(bs[4:], msg.id)
+	endian.PutUint16(bs[8:], msg.code)
+	this.putVersionFlag(bs, msg, headerLength, vfCompressed)
+	bs[offset] = compressor.Id
+	endian.PutUint32(bs[offset+1:], uint32(len(msg.Body)))
+	copy(bs[offset+compressedMin:], compressed)
+	return bs, nil
+}
+
+//写入version/flag，有剩余等待时间时写在固定头部之后
+func (this *tenuredCoder) putVersionFlag(bs []byte, msg *TenuredCommand, headerLength uint32, bits uint32) {
+	vf := (uint32(msg.Version&0xFF) << 24) | bits | uint32((headerLength&0xFFFFF)<<2) | uint32(msg.flag&3 /*0b11*/)
+	if timeout := this.timeoutOf(msg); timeout > 0 {
+		vf |= vfTimeout
+		endian.PutUint32(bs[lengthMin:], timeout)
+	}
+	endian.PutUint32(bs[10:], vf)
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+//随机测试的轮数
+const fuzzRounds = 2000
+
+//golden帧作为种子，随机翻转、截断、追加字节生成测试数据，固定随机种子保证可以重现
+func fuzzInputs(t *testing.T) [][]byte {
+	seeds := make([][]byte, 0)
+	for _, frame := range goldenFrames() {
+		if golden, err := readGolden(filepath.Join("testdata", "frames", frame.name+".hex")); err == nil {
+			seeds = append(seeds, golden)
+		}
+	}
+	if len(seeds) == 0 {
+		t.Fatal("no golden frames")
+	}
+
+	random := rand.New(rand.NewSource(20191018))
+	inputs := make([][]byte, 0, len(seeds)+fuzzRounds)
+	inputs = append(inputs, seeds...)
+	for i := 0; i < fuzzRounds; i++ {
+		data := append([]byte{}, seeds[random.Intn(len(seeds))]...)
+		switch random.Intn(4) {
+		case 0:
+			for n := random.Intn(4) + 1; n > 0; n-- {
+				data[random.Intn(len(data))] ^= byte(random.Intn(255) + 1)
+			}
+		case 1:
+			data = data[:random.Intn(len(data))]
+		case 2:
+			extra := make([]byte, random.Intn(32)+1)
+			random.Read(extra)
+			data = append(data, extra...)
+		case 3:
+			data = make([]byte, random.Intn(64))
+			random.Read(data)
+		}
+		inputs = append(inputs, data)
+	}
+	return inputs
+}
+
+//任意数据解码不能panic，解码成功的消息重新编码后解码结果一致
+func TestTenuredCoder_DecodeRandom(t *testing.T) {
+	for _, data := range fuzzInputs(t) {
+		config := remoting.DefaultConfig()
+		coder := &tenuredCoder{config: config}
+		msg, err := coder.Decode(nil, bytes.NewReader(data))
+		if err != nil || msg == nil {
+			continue
+		}
+		//压缩帧解压后可能超过PacketBytesLimit，重新编码时不分片
+		command := msg.(*TenuredCommand)
+		plain := remoting.DefaultConfig()
+		plain.PacketBytesLimit = config.PacketBytesLimit*maxCompressRatio + lengthMin
+		encoder := &tenuredCoder{config: plain}
+		encoder.setDeadline(true)
+		encoded, err := encoder.Encode(nil, command)
+		if err != nil {
+			t.Fatalf("%x: %s", data, err)
+		}
+		decoded, err := (&tenuredCoder{config: plain}).Decode(nil, bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("%x: %s", data, err)
+		}
+		assertCommandEqual(t, command, decoded.(*TenuredCommand))
+	}
+}
+
+//任意的分段读取和读超时，BufferedReader解码出的消息和一次读取完整数据时相同
+func TestTenuredCoder_PartialReadRandom(t *testing.T) {
+	for _, data := range fuzzInputs(t) {
+		config := remoting.DefaultConfig()
+		expect, err := (&tenuredCoder{config: config}).Decode(nil, bytes.NewReader(data))
+		if err != nil || expect == nil {
+			continue
+		}
+
+		sizes := make([]int, 0, 8)
+		for i := 0; i < len(data) && len(sizes) < 8; i++ {
+			sizes = append(sizes, int(data[i]%16)+1)
+		}
+		partialDecode(t, config, data, sizes, expect.(*TenuredCommand))
+	}
+}
+
+func partialDecode(t *testing.T, config *remoting.RemotingConfig, data []byte, sizes []int, expect *TenuredCommand) {
+	reader := remoting.NewBufferedReader(&choppyReader{data: data, sizes: sizes})
+	defer reader.Close()
+	coder := &tenuredCoder{config: config}
+	for attempts := 0; attempts < 100000; attempts++ {
+		reader.Begin()
+		msg, err := coder.Decode(nil, reader)
+		if err != nil {
+			if !isTimeoutErr(err) {
+				t.Fatalf("%x: %s", data, err)
+			}
+			reader.Rollback()
+			continue
+		}
+		assertCommandEqual(t, expect, msg.(*TenuredCommand))
+		return
+	}
+	t.Fatalf("%x: decode not finished", data)
+}
@@ -10,6 +10,8 @@ import (
 var c = tenuredCoder{config: remoting.DefaultConfig()}
 
 func TestTenuredCoder_Request(t *testing.T) {
+	//包内其他测试文件在此之前运行，已经使用了消息ID
+	atomicId.Set(0)
 	request := NewRequest(1)
 	_ = request.SetHeader(map[string]string{"name": "value"})
 	request.Body = []byte("testbody")
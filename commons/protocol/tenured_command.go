@@ -1,10 +1,14 @@
 package protocol
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"github.com/ihaiker/tenured-go-server/commons/atomic"
+	"math"
+	"strconv"
+	"time"
 )
 
 const FLAG_ACK = 2    //0b10
@@ -14,6 +18,35 @@ const RESPONSE_SUCCESS = 0
 const REQUEST_CODE_IDLE = uint16(0)
 const REQUEST_CODE_ATUH = uint16(1)
 
+//分片帧，超过PacketBytesLimit的消息拆分后传输，由tenuredCoder重新组装
+const REQUEST_CODE_FRAGMENT = uint16(10)
+
+//服务端即将关闭，收到的客户端不再选择此服务实例，并等待已发送的请求完成
+const REQUEST_CODE_GOAWAY = uint16(11)
+
+//取消请求，id为需要取消的请求ID，请求方等待超时或者放弃请求时发送，服务端取消请求处理的Context
+const REQUEST_CODE_CANCEL = uint16(12)
+
+//协议内部使用的请求码，不是业务请求
+func isInternalCode(code uint16) bool {
+	switch code {
+	case REQUEST_CODE_IDLE, REQUEST_CODE_ATUH, REQUEST_CODE_FRAGMENT, REQUEST_CODE_GOAWAY, REQUEST_CODE_CANCEL:
+		return true
+	}
+	return false
+}
+
+//解析请求码，可以使用数字或者RegisterCommandName注册的名称
+func ParseCommandCode(command string) (uint16, error) {
+	if code, has := CommandCode(command); has {
+		return code, nil
+	}
+	if code, err := strconv.ParseUint(command, 10, 16); err == nil {
+		return uint16(code), nil
+	}
+	return 0, errors.New(fmt.Sprintf("unknown command: %s", command))
+}
+
 const ErrNoHeader = commons.Error("NoHeader")
 
 var atomicId atomic.AtomicUInt32
@@ -29,7 +62,7 @@ type TenuredCommand struct {
 	//消息类型，查阅RequestCode，如果（flag & 0b10 > 0）&& code != 0，返回请求错误值ResponseCode。
 	code uint16
 
-	//当前消息版本号，用户兼容相同消息的不同的版本(预留了255个可升级)
+	//当前消息版本号，用户兼容相同消息的不同的版本。低5位为版本号(预留了31个可升级)，高3位为header编码，查阅HeaderCodec
 	Version uint8
 
 	//第一位标识是否是请求，0=请求，1=FLAG_ACK。第二位：0:不是，1：单向通知，不需要回复
@@ -40,6 +73,12 @@ type TenuredCommand struct {
 
 	//	消息内容body，用户传递消息内容体字节流，如果消息类型是ACK且code != 0 此处传递是错误消息内容描述，且不经过base64处理。可用为空
 	Body []byte
+
+	//请求方剩余的等待时间(毫秒)，0不限制。服务端收到后作为处理请求的截止时间
+	timeout uint32
+
+	//服务端处理请求的上下文
+	ctx context.Context
 }
 
 func (this *TenuredCommand) ID() uint32 {
@@ -71,11 +110,57 @@ func (this *TenuredCommand) MakeOneway() *TenuredCommand {
 	return this
 }
 
+//设置请求方等待的时间，服务端处理时通过Context()获取截止时间
+func (this *TenuredCommand) SetTimeout(timeout time.Duration) *TenuredCommand {
+	if timeout <= 0 {
+		this.timeout = 0
+	} else if ms := timeout / time.Millisecond; ms >= math.MaxUint32 {
+		this.timeout = math.MaxUint32
+	} else if ms == 0 {
+		this.timeout = 1
+	} else {
+		this.timeout = uint32(ms)
+	}
+	return this
+}
+
+func (this *TenuredCommand) Timeout() time.Duration {
+	return time.Duration(this.timeout) * time.Millisecond
+}
+
+//服务端处理请求的上下文，请求方设置了超时时间时包含截止时间，请求方取消请求或者连接断开时取消
+func (this *TenuredCommand) Context() context.Context {
+	if this.ctx == nil {
+		return context.Background()
+	}
+	return this.ctx
+}
+
+//设置header编码，需要在SetHeader之前设置，nil使用json
+func (this *TenuredCommand) SetHeaderCodec(headerCodec *HeaderCodec) *TenuredCommand {
+	id := HEADER_CODEC_JSON
+	if headerCodec != nil {
+		id = headerCodec.Id
+	}
+	this.Version = (this.Version &^ headerCodecMask) | (id << headerCodecShift)
+	return this
+}
+
+//header编码，不支持的编码返回nil
+func (this *TenuredCommand) HeaderCodec() *HeaderCodec {
+	headerCodec, _ := headerCodecOf(this.Version)
+	return headerCodec
+}
+
 func (this *TenuredCommand) SetHeader(header interface{}) error {
 	if header == nil {
 		return ErrNoHeader
 	}
-	if bs, err := json.Marshal(header); err != nil {
+	headerCodec, err := headerCodecOf(this.Version)
+	if err != nil {
+		return err
+	}
+	if bs, err := headerCodec.Marshal(header); err != nil {
 		return err
 	} else {
 		this.header = bs
@@ -87,7 +172,11 @@ func (this *TenuredCommand) GetHeader(header interface{}) error {
 	if this.header == nil || header == nil {
 		return ErrNoHeader
 	}
-	return json.Unmarshal(this.header, header)
+	headerCodec, err := headerCodecOf(this.Version)
+	if err != nil {
+		return err
+	}
+	return headerCodec.Unmarshal(this.header, header)
 }
 
 func (this *TenuredCommand) Error(error, message string) *TenuredCommand {
@@ -130,6 +219,24 @@ func NewACK(id uint32) *TenuredCommand {
 	return rc
 }
 
+//请求的响应，header使用和请求相同的编码
+func NewResponse(request *TenuredCommand) *TenuredCommand {
+	rc := NewACK(request.id)
+	rc.Version = request.Version & headerCodecMask
+	return rc
+}
+
 func NewIdle() *TenuredCommand {
 	return NewRequest(REQUEST_CODE_IDLE)
 }
+
+func NewGoaway() *TenuredCommand {
+	return NewRequest(REQUEST_CODE_GOAWAY).MakeOneway()
+}
+
+func NewCancel(id uint32) *TenuredCommand {
+	rc := &TenuredCommand{}
+	rc.id = id
+	rc.code = REQUEST_CODE_CANCEL
+	return rc.MakeOneway()
+}
@@ -3,6 +3,8 @@ package protocol
 import (
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sync"
+	"time"
 )
 
 type TenuredCommandProcesser func(channel remoting.RemotingChannel, request *TenuredCommand)
@@ -12,19 +14,107 @@ type tenuredCommandRunner struct {
 	executorService executors.ExecutorService
 }
 
-func (this *tenuredCommandRunner) onCommand(channel remoting.RemotingChannel, command *TenuredCommand) {
+//处理请求，处理完成后调用done
+func (this *tenuredCommandRunner) onCommand(channel remoting.RemotingChannel, command *TenuredCommand,
+	interceptors []ServerInterceptor, done func()) {
 	if this.process == nil {
 		logger.Warnf("can't found command(%d) process", command.code)
+		done()
 		return
 	}
 
 	if this.executorService != nil {
 		if err := this.executorService.Execute(func() {
-			this.process(channel, command)
+			defer done()
+			this.run(channel, command, interceptors)
 		}); err != nil {
+			done()
 			logger.Errorf("command is error: %v", err)
 		}
 	} else {
-		this.process(channel, command)
+		defer done()
+		this.run(channel, command, interceptors)
 	}
 }
+
+//在线程池中等待时已经超过截止时间或者被取消的请求不再处理
+func (this *tenuredCommandRunner) run(channel remoting.RemotingChannel, command *TenuredCommand, interceptors []ServerInterceptor) {
+	if err := command.Context().Err(); err != nil {
+		logger.Debugf("command(%d) %d skipped: %v", command.code, command.id, err)
+		this.reject(channel, command, ErrorCanceled(err))
+		return
+	}
+	handler := chainServer(interceptors, this.handle)
+	if response, err := handler(channel, command); err != nil {
+		this.reject(channel, command, err)
+	} else if response != nil && !command.IsOneway() {
+		if err := channel.Write(response, time.Second*7); err != nil && !remoting.IsRemotingError(err, remoting.ErrClosed) {
+			logger.Warnf("send command(%d) %d response error: %v", command.code, command.id, err)
+		}
+	}
+}
+
+//调用处理器，处理器写出的响应由拦截器链返回
+func (this *tenuredCommandRunner) handle(channel remoting.RemotingChannel, request *TenuredCommand) (*TenuredCommand, *TenuredError) {
+	responseChannel := &responseChannel{RemotingChannel: channel, id: request.id}
+	this.process(responseChannel, request)
+	return responseChannel.finish(), nil
+}
+
+func (this *tenuredCommandRunner) reject(channel remoting.RemotingChannel, command *TenuredCommand, err *TenuredError) {
+	if command.IsOneway() {
+		return
+	}
+	if err := channel.Write(NewACK(command.id).RemotingError(err), time.Second); err != nil {
+		logger.Debugf("send command(%d) %d error response: %v", command.code, command.id, err)
+	}
+}
+
+//处理器处理期间写出的请求响应不直接发送，保存后经过拦截器链返回。
+//处理器返回后再写出的响应(例如在其他协程中)直接发送
+type responseChannel struct {
+	remoting.RemotingChannel
+	id uint32
+
+	lock     sync.Mutex
+	finished bool
+	response *TenuredCommand
+}
+
+func (this *responseChannel) capture(msg interface{}) bool {
+	command, match := msg.(*TenuredCommand)
+	if !match || !command.IsACK() || command.id != this.id {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.finished {
+		return false
+	}
+	this.response = command
+	return true
+}
+
+func (this *responseChannel) finish() *TenuredCommand {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.finished = true
+	return this.response
+}
+
+func (this *responseChannel) Write(msg interface{}, timeout time.Duration) error {
+	if this.capture(msg) {
+		return nil
+	}
+	return this.RemotingChannel.Write(msg, timeout)
+}
+
+func (this *responseChannel) AsyncWrite(msg interface{}, timeout time.Duration, callback func(error)) {
+	if this.capture(msg) {
+		if callback != nil {
+			callback(nil)
+		}
+		return
+	}
+	this.RemotingChannel.AsyncWrite(msg, timeout, callback)
+}
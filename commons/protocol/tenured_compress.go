@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+//认证时协商压缩算法使用的属性名称，客户端传递支持的算法列表(逗号分隔)，服务端返回选择的算法
+const AUTH_ATTRIBUTE_COMPRESS = "compress"
+
+//压缩算法，Id写入压缩帧中用于解压
+type Compressor struct {
+	Id        uint8
+	Name      string
+	NewWriter func(w io.Writer) io.WriteCloser
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+func (this *Compressor) Compress(bs []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(bs)/2))
+	writer := this.NewWriter(buf)
+	if _, err := writer.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//解压数据，解压后长度必须为size
+func (this *Compressor) Decompress(bs []byte, size int) ([]byte, error) {
+	reader, err := this.NewReader(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	out := make([]byte, size)
+	if _, err := io.ReadFull(reader, out); err != nil {
+		return nil, err
+	}
+	if n, _ := reader.Read(make([]byte, 1)); n != 0 {
+		return nil, io.ErrShortBuffer
+	}
+	return out, nil
+}
+
+var compressors = map[uint8]*Compressor{}
+
+func RegisterCompressor(compressor *Compressor) {
+	compressors[compressor.Id] = compressor
+}
+
+func GetCompressor(name string) *Compressor {
+	for _, compressor := range compressors {
+		if compressor.Name == name {
+			return compressor
+		}
+	}
+	return nil
+}
+
+func splitCompress(names string) []string {
+	out := make([]string, 0)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+//按照服务端配置的顺序选择客户端也支持的压缩算法，没有时返回nil
+func negotiateCompress(serverCompress, clientCompress string) *Compressor {
+	clients := splitCompress(clientCompress)
+	for _, name := range splitCompress(serverCompress) {
+		for _, clientName := range clients {
+			if name == clientName {
+				if compressor := GetCompressor(name); compressor != nil {
+					return compressor
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterCompressor(&Compressor{
+		Id: 1, Name: "gzip",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			return gzip.NewWriter(w)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+	RegisterCompressor(&Compressor{
+		Id: 2, Name: "deflate",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			writer, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return writer
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	})
+}
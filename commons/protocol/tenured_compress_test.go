@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTenuredCoder_Compress(t *testing.T) {
+	for _, name := range []string{"gzip", "deflate"} {
+		coder := &tenuredCoder{config: remoting.DefaultConfig()}
+		coder.setCompressor(GetCompressor(name))
+
+		request := NewRequest(HELLO)
+		_ = request.SetHeader(map[string]string{"name": strings.Repeat("value", 100)})
+		request.Body = []byte(strings.Repeat("tenured body ", 300))
+		bs, err := coder.Encode(nil, request)
+		assert.Nil(t, err)
+		assert.True(t, len(bs) < coder.config.PacketBytesLimit)
+		assert.True(t, endian.Uint32(bs[10:])&vfCompressed != 0)
+
+		//未协商压缩的coder同样可以解压
+		decoder := &tenuredCoder{config: remoting.DefaultConfig()}
+		msg, err := decoder.Decode(nil, bytes.NewReader(bs))
+		assert.Nil(t, err)
+		decoded := msg.(*TenuredCommand)
+		assert.Equal(t, request.id, decoded.id)
+		assert.Equal(t, request.code, decoded.code)
+		assert.Equal(t, string(request.header), string(decoded.header))
+		assert.Equal(t, string(request.Body), string(decoded.Body))
+	}
+}
+
+func TestTenuredCoder_CompressThreshold(t *testing.T) {
+	coder := &tenuredCoder{config: remoting.DefaultConfig()}
+	coder.setCompressor(GetCompressor("gzip"))
+
+	request := NewRequest(HELLO)
+	request.Body = []byte("hello")
+	bs, err := coder.Encode(nil, request)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), endian.Uint32(bs[10:])&vfCompressed)
+}
+
+func TestNegotiateCompress(t *testing.T) {
+	assert.Equal(t, "deflate", negotiateCompress("deflate,gzip", "gzip, deflate").Name)
+	assert.Equal(t, "gzip", negotiateCompress("deflate,gzip", "gzip").Name)
+	assert.Nil(t, negotiateCompress("deflate,gzip", ""))
+	assert.Nil(t, negotiateCompress("", "gzip"))
+	assert.Nil(t, negotiateCompress("snappy", "snappy"))
+}
+
+func TestTenured_Compress(t *testing.T) {
+	address := "pipe://tenured-compress"
+	serverConfig := remoting.DefaultConfig()
+	serverConfig.Compress = "deflate,gzip"
+	server, _ := NewTenuredServer(address, serverConfig)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	body := []byte(strings.Repeat("tenured body ", 500))
+
+	clientConfig := remoting.DefaultConfig()
+	clientConfig.Compress = "gzip"
+	compressClient, _ := NewTenuredClient(clientConfig)
+	compressClient.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, compressClient.Start())
+	defer compressClient.Shutdown(true)
+
+	request := NewRequest(HELLO)
+	request.Body = body
+	response, err := compressClient.Invoke(address, request, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, string(body), string(response.Body))
+
+	//没有声明压缩的客户端不受影响
+	plainConfig := remoting.DefaultConfig()
+	plainConfig.MaxMessageBytes = 0
+	plainClient, _ := NewTenuredClient(plainConfig)
+	plainClient.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, plainClient.Start())
+	defer plainClient.Shutdown(true)
+
+	request = NewRequest(HELLO)
+	request.Body = []byte(strings.Repeat("a", 600))
+	response, err = plainClient.Invoke(address, request, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, string(request.Body), string(response.Body))
+
+	request = NewRequest(HELLO)
+	request.Body = body
+	_, err = plainClient.Invoke(address, request, time.Second)
+	assert.True(t, remoting.IsRemotingError(err, remoting.ErrPacketBytesLimit))
+}
@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"context"
+	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTenured_Deadline(t *testing.T) {
+	address := "pipe://tenured-deadline"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	deadlines := make(chan time.Duration, 1)
+	errs := make(chan error, 1)
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		if deadline, has := command.Context().Deadline(); has {
+			deadlines <- time.Until(deadline)
+		} else {
+			deadlines <- 0
+		}
+		select {
+		case <-command.Context().Done():
+			errs <- command.Context().Err()
+		case <-time.After(time.Second * 3):
+			errs <- nil
+		}
+	}, executors.NewFixedExecutorService(2, 10))
+
+	//单线程处理，排队时已经超时的请求不再处理
+	processed := make(chan string, 2)
+	server.RegisterCommandProcesser(HELLO+1, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		processed <- string(command.Body)
+		time.Sleep(time.Millisecond * 500)
+	}, executors.NewSingleExecutorService(10))
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	//调用超时后服务端的处理被取消
+	_, err = client.Invoke(address, NewRequest(HELLO), time.Millisecond*300)
+	assert.NotNil(t, err)
+	remaining := <-deadlines
+	assert.True(t, remaining > 0 && remaining <= time.Millisecond*300, remaining.String())
+	select {
+	case err := <-errs:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("request not canceled")
+	}
+
+	//没有设置等待时间的请求，由取消请求取消
+	channel, err := client.remoting.GetChannel(address, time.Second)
+	assert.Nil(t, err)
+	request := NewRequest(HELLO)
+	assert.Nil(t, channel.Write(request, time.Second))
+	assert.Equal(t, time.Duration(0), <-deadlines)
+	assert.Nil(t, channel.Write(NewCancel(request.ID()), time.Second))
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("request not canceled")
+	}
+
+	results := make(chan error, 2)
+	for _, body := range []string{"first", "second"} {
+		request := NewRequest(HELLO + 1)
+		request.Body = []byte(body)
+		client.AsyncInvoke(address, request, time.Millisecond*300, func(response *TenuredCommand, err error) {
+			results <- err
+		})
+		time.Sleep(time.Millisecond * 50)
+	}
+	<-results
+	<-results
+	time.Sleep(time.Millisecond * 600)
+	assert.Equal(t, 1, len(processed))
+	assert.Equal(t, "first", <-processed)
+}
+
+//对端确认前和认证请求不写入剩余等待时间，旧版本可以正常解码
+func TestTenuredCoder_DeadlineNegotiation(t *testing.T) {
+	coder := &tenuredCoder{config: remoting.DefaultConfig()}
+	request := NewRequest(HELLO)
+	request.SetTimeout(time.Second)
+	bs, err := coder.Encode(nil, request)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), endian.Uint32(bs[10:])&vfTimeout)
+
+	coder.setDeadline(true)
+	bs, err = coder.Encode(nil, request)
+	assert.Nil(t, err)
+	assert.Equal(t, vfTimeout, endian.Uint32(bs[10:])&vfTimeout)
+
+	auth := NewRequest(REQUEST_CODE_ATUH)
+	auth.SetTimeout(time.Second * 3)
+	_ = auth.SetHeader(&AuthHeader{Module: "test", Attributes: map[string]string{}})
+	bs, err = coder.Encode(nil, auth)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), endian.Uint32(bs[10:])&vfTimeout)
+	assert.Equal(t, byte('{'), bs[lengthMin])
+}
+
+func TestTenuredCommand_SetTimeout(t *testing.T) {
+	command := NewRequest(HELLO)
+	assert.Equal(t, uint32(1), command.SetTimeout(time.Microsecond).timeout)
+	assert.Equal(t, uint32(0), command.SetTimeout(-time.Second).timeout)
+	assert.Equal(t, time.Second*3, command.SetTimeout(time.Second*3).Timeout())
+
+	//调用方设置的更短的等待时间不被覆盖
+	withTimeout(command, time.Second*5)
+	assert.Equal(t, time.Second*3, command.Timeout())
+	withTimeout(command, time.Second)
+	assert.Equal(t, time.Second, command.Timeout())
+	assert.Equal(t, context.Background(), command.Context())
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTenuredServer_Drain(t *testing.T) {
+	address := "pipe://tenured-drain"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	started := make(chan struct{})
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		if string(command.Body) == "drain" {
+			close(started)
+			time.Sleep(time.Millisecond * 300)
+		}
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	_, err = client.Invoke(address, NewRequest(HELLO), time.Second*3)
+	assert.Nil(t, err)
+	assert.True(t, client.IsAvailable(address))
+
+	responses := make(chan *TenuredCommand, 1)
+	go func() {
+		request := NewRequest(HELLO)
+		request.Body = []byte("drain")
+		response, err := client.Invoke(address, request, time.Second*3)
+		assert.Nil(t, err)
+		responses <- response
+	}()
+	<-started
+
+	//正常关闭等待正在处理的请求完成
+	server.Shutdown(false)
+	select {
+	case response := <-responses:
+		assert.True(t, response.IsSuccess())
+		assert.Equal(t, "drain", string(response.Body))
+	case <-time.After(time.Second):
+		t.Fatal("in-flight request not finished")
+	}
+	assert.False(t, client.IsAvailable(address))
+}
@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func randomBody(size int) []byte {
+	bs := make([]byte, size)
+	_, _ = rand.Read(bs)
+	return bs
+}
+
+//拆分编码后的分片帧
+func splitFrames(bs []byte) [][]byte {
+	frames := make([][]byte, 0)
+	for len(bs) > 0 {
+		length := endian.Uint32(bs)
+		frames = append(frames, bs[:length])
+		bs = bs[length:]
+	}
+	return frames
+}
+
+func TestTenuredCoder_Fragment(t *testing.T) {
+	coder := &tenuredCoder{config: remoting.DefaultConfig()}
+	request := NewRequest(HELLO)
+	_ = request.SetHeader(map[string]string{"name": "value"})
+	request.Body = randomBody(5000)
+
+	bs, err := coder.Encode(nil, request)
+	assert.Nil(t, err)
+	frames := splitFrames(bs)
+	assert.True(t, len(frames) > 1)
+	for _, frame := range frames {
+		assert.True(t, len(frame) <= coder.config.PacketBytesLimit)
+	}
+
+	reader := bytes.NewReader(bs)
+	for i := 0; i < len(frames)-1; i++ {
+		msg, err := coder.Decode(nil, reader)
+		assert.Nil(t, err)
+		assert.Nil(t, msg)
+	}
+	msg, err := coder.Decode(nil, reader)
+	assert.Nil(t, err)
+	decoded := msg.(*TenuredCommand)
+	assert.Equal(t, request.id, decoded.id)
+	assert.Equal(t, HELLO, decoded.code)
+	assert.Equal(t, string(request.header), string(decoded.header))
+	assert.True(t, bytes.Equal(request.Body, decoded.Body))
+	assert.Equal(t, 0, len(coder.partials))
+}
+
+func TestTenuredCoder_FragmentInterleaved(t *testing.T) {
+	coder := &tenuredCoder{config: remoting.DefaultConfig()}
+	request := NewRequest(HELLO)
+	request.Body = randomBody(3000)
+	ack := NewACK(request.id)
+	ack.Body = randomBody(3000)
+
+	requestBytes, _ := coder.Encode(nil, request)
+	ackBytes, _ := coder.Encode(nil, ack)
+	requestFrames, ackFrames := splitFrames(requestBytes), splitFrames(ackBytes)
+
+	//相同id的请求和响应分片交替到达
+	stream := make([]byte, 0)
+	for i := 0; i < len(requestFrames) || i < len(ackFrames); i++ {
+		if i < len(requestFrames) {
+			stream = append(stream, requestFrames[i]...)
+		}
+		if i < len(ackFrames) {
+			stream = append(stream, ackFrames[i]...)
+		}
+	}
+	reader := bytes.NewReader(stream)
+	decoded := make([]*TenuredCommand, 0)
+	for reader.Len() > 0 {
+		msg, err := coder.Decode(nil, reader)
+		assert.Nil(t, err)
+		if msg != nil {
+			decoded = append(decoded, msg.(*TenuredCommand))
+		}
+	}
+	assert.Equal(t, 2, len(decoded))
+	for _, command := range decoded {
+		if command.IsACK() {
+			assert.True(t, bytes.Equal(ack.Body, command.Body))
+		} else {
+			assert.True(t, bytes.Equal(request.Body, command.Body))
+		}
+	}
+}
+
+func TestTenuredCoder_FragmentLimit(t *testing.T) {
+	encoder := &tenuredCoder{config: remoting.DefaultConfig()}
+	request := NewRequest(HELLO)
+	request.Body = randomBody(5000)
+	bs, _ := encoder.Encode(nil, request)
+
+	//超过最大消息长度
+	config := remoting.DefaultConfig()
+	config.MaxMessageBytes = 4000
+	_, err := (&tenuredCoder{config: config}).Decode(nil, bytes.NewReader(bs))
+	assert.True(t, remoting.IsRemotingError(err, remoting.ErrPacketBytesLimit))
+	_, err = (&tenuredCoder{config: config}).Encode(nil, request)
+	assert.True(t, remoting.IsRemotingError(err, remoting.ErrPacketBytesLimit))
+
+	//超过同时接收的分片消息数量
+	config = remoting.DefaultConfig()
+	config.MaxPartialMessages = 1
+	decoder := &tenuredCoder{config: config}
+	other := NewRequest(HELLO)
+	other.Body = randomBody(5000)
+	otherBytes, _ := encoder.Encode(nil, other)
+	msg, err := decoder.Decode(nil, bytes.NewReader(splitFrames(bs)[0]))
+	assert.Nil(t, msg)
+	assert.Nil(t, err)
+	_, err = decoder.Decode(nil, bytes.NewReader(splitFrames(otherBytes)[0]))
+	assert.NotNil(t, err)
+
+	//关闭后丢弃未完整的分片
+	decoder.OnClose(nil)
+	assert.Equal(t, 0, len(decoder.partials))
+}
+
+func TestTenured_Fragment(t *testing.T) {
+	address := "pipe://tenured-fragment"
+	server, _ := NewTenuredServer(address, nil)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	fragmentClient, _ := NewTenuredClient(nil)
+	fragmentClient.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, fragmentClient.Start())
+	defer fragmentClient.Shutdown(true)
+
+	request := NewRequest(HELLO)
+	request.Body = randomBody(20 * 1024)
+	response, err := fragmentClient.Invoke(address, request, time.Second*3)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(request.Body, response.Body))
+}
@@ -0,0 +1,205 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//go test -run Golden -update 重新生成 testdata/frames 下的帧文件
+var updateGolden = flag.Bool("update", false, "update golden frame files")
+
+type goldenFrame struct {
+	name    string
+	command *TenuredCommand
+	//压缩算法的输出可能随go版本变化，只校验解码
+	compress string
+}
+
+func goldenFrames() []goldenFrame {
+	withHeader := &TenuredCommand{id: 2, code: HELLO, Version: 1}
+	_ = withHeader.SetHeader(&AuthHeader{Module: "store", Address: "127.0.0.1:6072"})
+	withHeader.Body = []byte("tenured body")
+
+	msgpackHeader := (&TenuredCommand{id: 7, code: HELLO, Version: 1}).SetHeaderCodec(GetHeaderCodec("msgpack"))
+	_ = msgpackHeader.SetHeader(&AuthHeader{Module: "store", Address: "127.0.0.1:6072"})
+
+	errAck := &TenuredCommand{id: 3, code: RESPONSE_SUCCESS}
+	errAck.MakeACK().RemotingError(&TenuredError{code: "1001", message: "test error"})
+
+	compressed := &TenuredCommand{id: 5, code: HELLO}
+	compressed.Body = bytes.Repeat([]byte("compressed body "), 64)
+
+	withTimeout := &TenuredCommand{id: 6, code: HELLO}
+	withTimeout.SetTimeout(time.Second * 3)
+	withTimeout.Body = []byte("deadline")
+
+	return []goldenFrame{
+		{name: "request", command: &TenuredCommand{id: 1, code: HELLO}},
+		{name: "request_header_body", command: withHeader},
+		{name: "ack_error", command: errAck},
+		{name: "oneway_goaway", command: (&TenuredCommand{id: 4, code: REQUEST_CODE_GOAWAY}).MakeOneway()},
+		{name: "compressed_gzip", command: compressed, compress: "gzip"},
+		{name: "request_timeout", command: withTimeout},
+		{name: "request_header_msgpack", command: msgpackHeader},
+	}
+}
+
+func goldenCoder(compress string) *tenuredCoder {
+	coder := &tenuredCoder{config: remoting.DefaultConfig()}
+	coder.setCompressor(GetCompressor(compress))
+	coder.setDeadline(true)
+	return coder
+}
+
+func assertCommandEqual(t *testing.T, expect, actual *TenuredCommand) {
+	assert.Equal(t, expect.id, actual.id)
+	assert.Equal(t, expect.code, actual.code)
+	assert.Equal(t, expect.flag, actual.flag)
+	assert.Equal(t, expect.Version, actual.Version)
+	assert.Equal(t, expect.timeout, actual.timeout)
+	assert.Equal(t, string(expect.header), string(actual.header))
+	assert.Equal(t, string(expect.Body), string(actual.Body))
+}
+
+func TestTenuredCoder_Golden(t *testing.T) {
+	for _, frame := range goldenFrames() {
+		path := filepath.Join("testdata", "frames", frame.name+".hex")
+		coder := goldenCoder(frame.compress)
+		encoded, err := coder.Encode(nil, frame.command)
+		assert.Nil(t, err)
+
+		if *updateGolden {
+			assert.Nil(t, ioutil.WriteFile(path, []byte(hex.Dump(encoded)), 0644))
+			continue
+		}
+
+		golden, err := readGolden(path)
+		if !assert.Nil(t, err, frame.name) {
+			continue
+		}
+		if frame.compress == "" {
+			assert.Equal(t, golden, encoded, frame.name)
+		}
+
+		msg, err := goldenCoder("").Decode(nil, bytes.NewReader(golden))
+		if assert.Nil(t, err, frame.name) {
+			assertCommandEqual(t, frame.command, msg.(*TenuredCommand))
+		}
+	}
+}
+
+//读取 hex -C 格式的文件
+func readGolden(path string) ([]byte, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(string(bs), "\n") {
+		if len(line) < 10 {
+			continue
+		}
+		//去掉偏移量和右侧的字符显示
+		line = line[10:]
+		if idx := strings.Index(line, "|"); idx > 0 {
+			line = line[:idx]
+		}
+		data, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+//每次返回很少的数据并且穿插读超时，模拟TCP短读和channel读超时
+type choppyReader struct {
+	data  []byte
+	sizes []int
+	count int
+}
+
+func (this *choppyReader) Read(p []byte) (int, error) {
+	this.count++
+	if len(this.data) == 0 {
+		return 0, &net.OpError{Op: "read", Net: "tcp", Err: &timeoutErr{}}
+	}
+	if this.count%3 == 0 {
+		return 0, &net.OpError{Op: "read", Net: "tcp", Err: &timeoutErr{}}
+	}
+	n := this.sizes[this.count%len(this.sizes)]
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(this.data) {
+		n = len(this.data)
+	}
+	copy(p, this.data[:n])
+	this.data = this.data[n:]
+	return n, nil
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func isTimeoutErr(err error) bool {
+	if remotingErr, match := err.(*remoting.RemotingError); match {
+		err = remotingErr.Err
+	}
+	netErr, match := err.(net.Error)
+	return match && netErr.Timeout()
+}
+
+func TestTenuredCoder_PartialRead(t *testing.T) {
+	config := remoting.DefaultConfig()
+	config.PacketBytesLimit = 256
+	encoder := &tenuredCoder{config: config}
+	encoder.setDeadline(true)
+	frames := goldenFrames()
+
+	stream := bytes.NewBuffer(nil)
+	for _, frame := range frames {
+		bs, err := encoder.Encode(nil, frame.command)
+		assert.Nil(t, err)
+		stream.Write(bs)
+	}
+
+	decoder := &tenuredCoder{config: config}
+	reader := remoting.NewBufferedReader(&choppyReader{data: stream.Bytes(), sizes: []int{1, 7, 2, 13, 5}})
+	defer reader.Close()
+
+	commands := make([]*TenuredCommand, 0)
+	for attempts := 0; len(commands) < len(frames) && attempts < 100000; attempts++ {
+		reader.Begin()
+		msg, err := decoder.Decode(nil, reader)
+		if err != nil {
+			if !assert.True(t, isTimeoutErr(err), err.Error()) {
+				return
+			}
+			reader.Rollback()
+			continue
+		}
+		reader.Commit()
+		if msg != nil {
+			commands = append(commands, msg.(*TenuredCommand))
+		}
+	}
+	if assert.Equal(t, len(frames), len(commands)) {
+		for i, frame := range frames {
+			assertCommandEqual(t, frame.command, commands[i])
+		}
+	}
+}
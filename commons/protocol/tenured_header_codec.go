@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+//认证时协商header编码使用的属性名称，客户端传递支持的编码列表(逗号分隔)，服务端返回选择的编码
+const AUTH_ATTRIBUTE_HEADER_CODEC = "headerCodec"
+
+//header编码Id保存在Version的高3位，低5位为消息版本号
+const headerCodecShift = 5
+const headerCodecMask = uint8(0xE0)
+
+const HEADER_CODEC_JSON = uint8(0)
+const HEADER_CODEC_MSGPACK = uint8(1)
+
+//服务的客户端和服务端默认支持的header编码，优先使用msgpack
+const DEFAULT_HEADER_CODECS = "msgpack,json"
+
+//header编码，Id写入消息的Version中，解码时根据Version选择
+type HeaderCodec struct {
+	Id        uint8
+	Name      string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var headerCodecs = map[uint8]*HeaderCodec{}
+
+func RegisterHeaderCodec(headerCodec *HeaderCodec) {
+	if headerCodec.Id > headerCodecMask>>headerCodecShift {
+		panic(fmt.Sprintf("header codec %s id %d out of range", headerCodec.Name, headerCodec.Id))
+	}
+	headerCodecs[headerCodec.Id] = headerCodec
+}
+
+func GetHeaderCodec(name string) *HeaderCodec {
+	for _, headerCodec := range headerCodecs {
+		if headerCodec.Name == name {
+			return headerCodec
+		}
+	}
+	return nil
+}
+
+func headerCodecOf(version uint8) (*HeaderCodec, error) {
+	id := (version & headerCodecMask) >> headerCodecShift
+	if headerCodec, has := headerCodecs[id]; has {
+		return headerCodec, nil
+	}
+	return nil, errors.New(fmt.Sprintf("not support header codec %d", id))
+}
+
+//按照服务端配置的顺序选择客户端也支持的header编码，没有时返回nil
+func negotiateHeaderCodec(serverCodec, clientCodec string) *HeaderCodec {
+	clients := splitCompress(clientCodec)
+	for _, name := range splitCompress(serverCodec) {
+		for _, clientName := range clients {
+			if name == clientName {
+				if headerCodec := GetHeaderCodec(name); headerCodec != nil {
+					return headerCodec
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var msgpackHandle = &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+
+func init() {
+	RegisterHeaderCodec(&HeaderCodec{
+		Id: HEADER_CODEC_JSON, Name: "json",
+		Marshal: json.Marshal, Unmarshal: json.Unmarshal,
+	})
+	RegisterHeaderCodec(&HeaderCodec{
+		Id: HEADER_CODEC_MSGPACK, Name: "msgpack",
+		Marshal: func(v interface{}) ([]byte, error) {
+			var bs []byte
+			err := codec.NewEncoderBytes(&bs, msgpackHandle).Encode(v)
+			return bs, err
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+		},
+	})
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestHeaderCodec(t *testing.T) {
+	header := &AuthHeader{Module: "store", Address: "127.0.0.1:6072", Attributes: map[string]string{"zone": "bj"}}
+	sizes := map[string]int{}
+	for _, name := range []string{"json", "msgpack"} {
+		command := NewRequest(HELLO)
+		command.Version = 3
+		command.SetHeaderCodec(GetHeaderCodec(name))
+		assert.Equal(t, name, command.HeaderCodec().Name)
+		//不影响消息版本号
+		assert.Equal(t, uint8(3), command.Version&^headerCodecMask)
+
+		assert.Nil(t, command.SetHeader(header))
+		sizes[name] = len(command.header)
+		out := &AuthHeader{}
+		assert.Nil(t, command.GetHeader(out))
+		assert.Equal(t, header, out)
+
+		response := NewResponse(command)
+		assert.Equal(t, name, response.HeaderCodec().Name)
+		assert.Equal(t, uint8(0), response.Version&^headerCodecMask)
+	}
+	assert.True(t, sizes["msgpack"] < sizes["json"])
+
+	//未注册的编码
+	command := NewRequest(HELLO)
+	command.Version = 7 << headerCodecShift
+	assert.Nil(t, command.HeaderCodec())
+	assert.NotNil(t, command.SetHeader(header))
+
+	assert.Equal(t, "msgpack", negotiateHeaderCodec("msgpack,json", "json,msgpack").Name)
+	assert.Nil(t, negotiateHeaderCodec("msgpack", "json"))
+}
+
+func TestTenured_HeaderCodec(t *testing.T) {
+	address := "pipe://tenured-header-codec"
+	serverConfig := remoting.DefaultConfig()
+	serverConfig.HeaderCodec = "msgpack,json"
+	server, err := NewTenuredServer(address, serverConfig)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	codecs := make(chan string, 1)
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		codecs <- command.HeaderCodec().Name
+		header := map[string]string{}
+		_ = command.GetHeader(&header)
+		header["server"] = "tenured"
+		response := NewResponse(command)
+		_ = response.SetHeader(header)
+		_ = channel.Write(response, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	clientConfig := remoting.DefaultConfig()
+	clientConfig.HeaderCodec = "msgpack"
+	client, err := NewTenuredClient(clientConfig)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	_, err = client.remoting.GetChannel(address, time.Second)
+	assert.Nil(t, err)
+	if assert.NotNil(t, client.HeaderCodec(address)) {
+		assert.Equal(t, "msgpack", client.HeaderCodec(address).Name)
+	}
+
+	//生成的客户端通过TenuredClientInvoke调用，默认配置透明使用协商的msgpack编码
+	invoke := NewClientInvoke(nil)
+	invoke.SetIdentity("test", "")
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+	serverInstance := &registry.ServerInstance{Address: address}
+	for i := 0; i < 2; i++ {
+		header := map[string]string{}
+		_, tenuredErr := invoke.Invoke(serverInstance, HELLO, map[string]string{"client": "tenured"}, nil, time.Second, &header)
+		assert.Nil(t, tenuredErr)
+		assert.Equal(t, map[string]string{"client": "tenured", "server": "tenured"}, header)
+		//第一次调用时连接还没有建立，使用json
+		if codec := <-codecs; i > 0 {
+			assert.Equal(t, "msgpack", codec)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sort"
+	"sync"
+	"time"
+)
+
+//计算p99保留的心跳往返时间样本数
+const healthSamples = 128
+
+//EWMA新样本的权重
+const healthEWMAWeight = 0.2
+
+//channel的心跳统计
+type HealthStats struct {
+	Address string
+
+	//心跳往返时间
+	Last time.Duration
+	EWMA time.Duration
+	P99  time.Duration
+
+	//收到的心跳响应数
+	Samples int
+
+	//连续没有收到响应的心跳数
+	Missed int
+
+	LastAck time.Time
+}
+
+type channelHealth struct {
+	lock    sync.Mutex
+	address string
+
+	samples [healthSamples]time.Duration
+	count   int
+	last    time.Duration
+	ewma    time.Duration
+	lastAck time.Time
+
+	missed  int
+	pingId  uint32
+	pinging bool
+}
+
+//发送心跳，上一个心跳还没有响应时记为丢失
+func (this *channelHealth) ping(id uint32) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.pinging {
+		this.missed++
+	}
+	this.pingId, this.pinging = id, true
+}
+
+func (this *channelHealth) pong(id uint32, rtt time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.pinging || this.pingId != id {
+		return
+	}
+	this.pinging, this.missed = false, 0
+	if this.count == 0 {
+		this.ewma = rtt
+	} else {
+		this.ewma = time.Duration(healthEWMAWeight*float64(rtt) + (1-healthEWMAWeight)*float64(this.ewma))
+	}
+	this.last = rtt
+	this.samples[this.count%healthSamples] = rtt
+	this.count++
+	this.lastAck = time.Now()
+}
+
+func (this *channelHealth) stats() HealthStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stats := HealthStats{
+		Address: this.address, Last: this.last, EWMA: this.ewma,
+		Samples: this.count, Missed: this.missed, LastAck: this.lastAck,
+	}
+	size := this.count
+	if size > healthSamples {
+		size = healthSamples
+	}
+	if size > 0 {
+		samples := make([]time.Duration, size)
+		copy(samples, this.samples[:size])
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stats.P99 = samples[(size*99-1)/100]
+	}
+	return stats
+}
+
+//合并同一地址多个channel的统计，取较差的值
+func mergeHealth(merged, stats HealthStats) HealthStats {
+	if stats.LastAck.After(merged.LastAck) {
+		merged.Last, merged.LastAck = stats.Last, stats.LastAck
+	}
+	if stats.EWMA > merged.EWMA {
+		merged.EWMA = stats.EWMA
+	}
+	if stats.P99 > merged.P99 {
+		merged.P99 = stats.P99
+	}
+	if stats.Missed > merged.Missed {
+		merged.Missed = stats.Missed
+	}
+	merged.Samples += stats.Samples
+	return merged
+}
+
+func (this *tenuredService) healthOf(channel remoting.RemotingChannel) *channelHealth {
+	this.healthLock.Lock()
+	defer this.healthLock.Unlock()
+	if this.healths == nil {
+		this.healths = map[remoting.RemotingChannel]*channelHealth{}
+	}
+	health, has := this.healths[channel]
+	if !has {
+		health = &channelHealth{address: channel.RemoteAddr()}
+		this.healths[channel] = health
+	}
+	return health
+}
+
+//心跳响应携带发送时间，计算往返时间
+func (this *tenuredService) onIdleAck(channel remoting.RemotingChannel, command *TenuredCommand) {
+	if len(command.Body) != 8 {
+		return
+	}
+	this.healthLock.RLock()
+	health, has := this.healths[channel]
+	this.healthLock.RUnlock()
+	if has {
+		sendAt := time.Unix(0, int64(commons.ToUInt64(command.Body)))
+		health.pong(command.id, time.Since(sendAt))
+	}
+}
+
+func (this *tenuredService) removeHealth(channel remoting.RemotingChannel) {
+	this.healthLock.Lock()
+	defer this.healthLock.Unlock()
+	delete(this.healths, channel)
+}
+
+//所有channel的心跳统计
+func (this *tenuredService) Healths() []HealthStats {
+	this.healthLock.RLock()
+	healths := make([]*channelHealth, 0, len(this.healths))
+	for _, health := range this.healths {
+		healths = append(healths, health)
+	}
+	this.healthLock.RUnlock()
+
+	out := make([]HealthStats, len(healths))
+	for i, health := range healths {
+		out[i] = health.stats()
+	}
+	return out
+}
+
+//地址的心跳统计，连接池中有多个channel时合并
+func (this *tenuredService) Health(address string) (HealthStats, bool) {
+	merged, has := HealthStats{Address: address}, false
+	for _, stats := range this.Healths() {
+		if stats.Address == address {
+			merged, has = mergeHealth(merged, stats), true
+		}
+	}
+	return merged, has
+}
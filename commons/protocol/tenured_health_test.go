@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChannelHealth(t *testing.T) {
+	health := &channelHealth{address: "127.0.0.1:6072"}
+	for i := 1; i <= 200; i++ {
+		health.ping(uint32(i))
+		health.pong(uint32(i), time.Duration(i)*time.Millisecond)
+	}
+	stats := health.stats()
+	assert.Equal(t, 200, stats.Samples)
+	assert.Equal(t, 200*time.Millisecond, stats.Last)
+	//只保留最近的样本
+	assert.Equal(t, 199*time.Millisecond, stats.P99)
+	assert.True(t, stats.EWMA > 190*time.Millisecond && stats.EWMA < 200*time.Millisecond, stats.EWMA.String())
+
+	//没有响应的心跳
+	health.ping(201)
+	health.ping(202)
+	health.ping(203)
+	//过期的响应不计算
+	health.pong(201, time.Second)
+	assert.Equal(t, 2, health.stats().Missed)
+	assert.Equal(t, 200*time.Millisecond, health.stats().Last)
+	health.pong(203, time.Millisecond)
+	assert.Equal(t, 0, health.stats().Missed)
+
+	merged := mergeHealth(HealthStats{}, health.stats())
+	merged = mergeHealth(merged, HealthStats{EWMA: time.Second, Missed: 3, Samples: 1})
+	assert.Equal(t, time.Second, merged.EWMA)
+	assert.Equal(t, 3, merged.Missed)
+	assert.Equal(t, 202, merged.Samples)
+}
+
+func TestTenured_Heartbeat(t *testing.T) {
+	address := "pipe://tenured-heartbeat"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	config := remoting.DefaultConfig()
+	config.IdleTime = 1
+	client, err := NewTenuredClient(config)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	_, err = client.remoting.GetChannel(address, time.Second)
+	assert.Nil(t, err)
+	_, has := client.Health(address)
+	assert.False(t, has)
+
+	var stats HealthStats
+	for i := 0; i < 30 && stats.Samples == 0; i++ {
+		time.Sleep(time.Millisecond * 100)
+		stats, has = client.Health(address)
+	}
+	assert.True(t, has)
+	assert.True(t, stats.Samples > 0)
+	assert.True(t, stats.EWMA > 0)
+	assert.Equal(t, 0, stats.Missed)
+	assert.Equal(t, 1, len(client.Healths()))
+
+	assert.True(t, client.IsAvailable(address))
+	client.MaxRTT = time.Nanosecond
+	assert.False(t, client.IsAvailable(address))
+}
@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"sort"
+	"sync"
+	"time"
+)
+
+//计算对冲延迟保留的响应时间样本数
+const hedgeSamples = 128
+
+//对冲请求策略，第一个请求在延迟时间内没有响应时向其他实例发送相同的请求，使用先返回的响应
+type HedgePolicy struct {
+	//使用此分位数的响应时间作为发送对冲请求的延迟，0-1
+	Percentile float64
+
+	//样本数不足时使用MaxDelay
+	MinSamples int
+
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+func DefaultHedgePolicy() *HedgePolicy {
+	return &HedgePolicy{
+		Percentile: 0.95,
+		MinSamples: 20,
+		MinDelay:   time.Millisecond * 5,
+		MaxDelay:   time.Millisecond * 500,
+	}
+}
+
+//请求码最近的响应时间
+type latencySamples struct {
+	lock    sync.Mutex
+	samples [hedgeSamples]time.Duration
+	count   int
+}
+
+func (this *latencySamples) add(latency time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.samples[this.count%hedgeSamples] = latency
+	this.count++
+}
+
+func (this *latencySamples) delay(policy *HedgePolicy) time.Duration {
+	this.lock.Lock()
+	size := this.count
+	if size > hedgeSamples {
+		size = hedgeSamples
+	}
+	if size == 0 || size < policy.MinSamples {
+		this.lock.Unlock()
+		return policy.MaxDelay
+	}
+	samples := make([]time.Duration, size)
+	copy(samples, this.samples[:size])
+	this.lock.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(size)*policy.Percentile+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	} else if idx >= size {
+		idx = size - 1
+	}
+	delay := samples[idx]
+	if delay < policy.MinDelay {
+		delay = policy.MinDelay
+	} else if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+type hedgeResult struct {
+	request  *TenuredCommand
+	response *TenuredCommand
+	err      error
+	elapsed  time.Duration
+}
+
+//设置对冲请求策略，为nil时不发送对冲请求
+func (this *TenuredClientInvoke) SetHedgePolicy(policy *HedgePolicy) {
+	this.hedgePolicy = policy
+}
+
+func (this *TenuredClientInvoke) latencyOf(code uint16) *latencySamples {
+	this.latencyLock.Lock()
+	defer this.latencyLock.Unlock()
+	if this.latencies == nil {
+		this.latencies = map[uint16]*latencySamples{}
+	}
+	latency, has := this.latencies[code]
+	if !has {
+		latency = &latencySamples{}
+		this.latencies[code] = latency
+	}
+	return latency
+}
+
+//请求码当前的对冲延迟
+func (this *TenuredClientInvoke) HedgeDelay(code uint16) time.Duration {
+	if this.hedgePolicy == nil {
+		return 0
+	}
+	return this.latencyOf(code).delay(this.hedgePolicy)
+}
+
+//对冲调用，只读方法使用，失败时同样按照重试策略重试
+func (this *TenuredClientInvoke) InvokeHedged(
+	loadBalance registry.LoadBalance, params []interface{},
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, *TenuredError) {
+	return this.retry(loadBalance, params, code, timeout,
+		func(serverInstances []*registry.ServerInstance, tried map[string]bool, timeout time.Duration) ([]byte, error) {
+			return this.hedged(loadBalance, params, serverInstances, tried, code, header, body, timeout, respHeader)
+		})
+}
+
+func (this *TenuredClientInvoke) hedged(
+	loadBalance registry.LoadBalance, params []interface{},
+	serverInstances []*registry.ServerInstance, tried map[string]bool,
+	code uint16, header interface{}, body []byte, timeout time.Duration, respHeader interface{},
+) ([]byte, error) {
+	primary := untried(serverInstances, tried)
+	if this.hedgePolicy == nil {
+		tried[primary.Address] = true
+		return this.invoke(primary, code, header, body, timeout, respHeader)
+	}
+
+	results := make(chan *hedgeResult, 2)
+	requests := make([]*TenuredCommand, 0, 2)
+	send := func(serverInstance *registry.ServerInstance) error {
+		tried[serverInstance.Address] = true
+		request, err := this.newRequest(serverInstance, code, header, body)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, request)
+		go func() {
+			startTime := time.Now()
+			response, err := this.call(serverInstance, request, timeout)
+			results <- &hedgeResult{request: request, response: response, err: err, elapsed: time.Since(startTime)}
+		}()
+		return nil
+	}
+
+	if err := send(primary); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(this.latencyOf(code).delay(this.hedgePolicy))
+	defer timer.Stop()
+
+	var firstErr error
+	for pending := 1; pending > 0; {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				this.latencyOf(code).add(result.elapsed)
+				//取消其他还在等待的请求
+				for _, request := range requests {
+					if request != result.request {
+						this.client.Cancel(request)
+					}
+				}
+				return decodeResponse(result.response, respHeader)
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+		case <-timer.C:
+			if hedge := this.hedgeInstance(loadBalance, params, serverInstances, tried); hedge != nil {
+				if err := send(hedge); err == nil {
+					pending++
+				}
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+//选择没有调用过的实例发送对冲请求，没有时返回nil
+func (this *TenuredClientInvoke) hedgeInstance(
+	loadBalance registry.LoadBalance, params []interface{},
+	serverInstances []*registry.ServerInstance, tried map[string]bool,
+) *registry.ServerInstance {
+	if selected := untried(serverInstances, tried); selected != nil && !tried[selected.Address] {
+		return selected
+	}
+	serverInstances, regKey, err := loadBalance.Select(params...)
+	if err != nil || len(serverInstances) == 0 {
+		return nil
+	}
+	defer loadBalance.Return(regKey)
+	if selected := untried(serverInstances, tried); selected != nil && !tried[selected.Address] {
+		return selected
+	}
+	return nil
+}
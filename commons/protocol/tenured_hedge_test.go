@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/executors"
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLatencySamples(t *testing.T) {
+	policy := &HedgePolicy{Percentile: 0.9, MinSamples: 10, MinDelay: time.Millisecond * 2, MaxDelay: time.Millisecond * 50}
+	latency := &latencySamples{}
+	assert.Equal(t, time.Millisecond*50, latency.delay(policy))
+	for i := 1; i <= 10; i++ {
+		latency.add(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond*9, latency.delay(policy))
+
+	policy.Percentile = 0
+	assert.Equal(t, time.Millisecond*2, latency.delay(policy))
+	latency.add(time.Second)
+	policy.Percentile = 1
+	assert.Equal(t, time.Millisecond*50, latency.delay(policy))
+}
+
+func TestTenuredClientInvoke_InvokeHedged(t *testing.T) {
+	canceled := make(chan bool, 1)
+	for _, address := range []string{"pipe://tenured-hedge-slow", "pipe://tenured-hedge-fast"} {
+		address := address
+		server, err := NewTenuredServer(address, nil)
+		assert.Nil(t, err)
+		server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+		server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			if address == "pipe://tenured-hedge-slow" {
+				select {
+				case <-command.Context().Done():
+					canceled <- true
+					return
+				case <-time.After(time.Second * 2):
+				}
+			}
+			response := NewResponse(command)
+			response.Body = []byte(address)
+			_ = channel.Write(response, time.Second)
+		}, executors.NewFixedExecutorService(2, 10))
+		assert.Nil(t, server.Start())
+		defer server.Shutdown(true)
+	}
+
+	invoke := NewClientInvoke(nil)
+	invoke.SetIdentity("test", "")
+	invoke.SetHedgePolicy(&HedgePolicy{Percentile: 0.95, MinSamples: 100, MaxDelay: time.Millisecond * 50})
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+
+	lb := &retryTestLoadBalance{instances: []*registry.ServerInstance{
+		{Address: "pipe://tenured-hedge-slow", Status: "OK"},
+		{Address: "pipe://tenured-hedge-fast", Status: "OK"},
+	}}
+	startTime := time.Now()
+	body, err := invoke.InvokeHedged(lb, nil, HELLO, nil, nil, time.Second*3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "pipe://tenured-hedge-fast", string(body))
+	assert.True(t, time.Since(startTime) < time.Second)
+
+	//慢的请求被取消
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request not canceled")
+	}
+	//被取消的调用返回后从等待表中删除
+	for i := 0; i < 20 && invoke.client.responseTables.size() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 0, invoke.client.responseTables.size())
+	assert.Equal(t, BREAKER_CLOSED, invoke.BreakerState(lb.instances[0]))
+
+	//没有对冲策略时只调用一个实例
+	invoke.SetHedgePolicy(nil)
+	lb.instances = lb.instances[1:]
+	body, err = invoke.InvokeHedged(lb, nil, HELLO, nil, nil, time.Second, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "pipe://tenured-hedge-fast", string(body))
+	assert.Equal(t, time.Duration(0), invoke.HedgeDelay(HELLO))
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"time"
+)
+
+//服务端处理请求，返回处理器写出的响应，单向请求或者处理器没有响应时为nil。
+//返回错误时如果请求不是单向请求由框架返回错误响应，否则由框架写出返回的响应
+type ServerHandler func(channel remoting.RemotingChannel, request *TenuredCommand) (*TenuredCommand, *TenuredError)
+
+//服务端拦截器，按照注册顺序包裹请求处理器。可以修改请求、不调用next直接返回错误，或者观察和修改next返回的响应
+type ServerInterceptor func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError)
+
+//客户端调用
+type Invoker func(address string, request *TenuredCommand, timeout time.Duration) (*TenuredCommand, error)
+
+//客户端拦截器，按照注册顺序包裹Invoke和AsyncInvoke
+type ClientInterceptor func(address string, request *TenuredCommand, timeout time.Duration, next Invoker) (*TenuredCommand, error)
+
+func chainServer(interceptors []ServerInterceptor, handler ServerHandler) ServerHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(channel remoting.RemotingChannel, request *TenuredCommand) (*TenuredCommand, *TenuredError) {
+			return interceptor(channel, request, next)
+		}
+	}
+	return handler
+}
+
+func chainClient(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(address string, request *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
+			return interceptor(address, request, timeout, next)
+		}
+	}
+	return invoker
+}
+
+//处理器panic时返回错误响应
+func RecoverInterceptor() ServerInterceptor {
+	return func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (response *TenuredCommand, err *TenuredError) {
+		defer func() {
+			if e := recover(); e != nil {
+				logger.Errorf("process command(%d) %d panic: %v", request.code, request.id, e)
+				err = ErrorHandler(commons.Catch(e))
+			}
+		}()
+		return next(channel, request)
+	}
+}
+
+//记录请求处理时间，超过slow时输出警告日志
+func LogServerInterceptor(slow time.Duration) ServerInterceptor {
+	return func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+		start := time.Now()
+		response, err := next(channel, request)
+		logErr := err
+		if logErr == nil && response != nil {
+			logErr = response.GetError()
+		}
+		if used := time.Since(start); slow > 0 && used > slow {
+			logger.Warnf("slow process command(%d) %d from %s: %s", request.code, request.id, channel.RemoteAddr(), used)
+		} else {
+			logger.Debugf("process command(%d) %d from %s: %s, error: %v", request.code, request.id, channel.RemoteAddr(), used, logErr)
+		}
+		return response, err
+	}
+}
+
+//记录调用时间，超过slow时输出警告日志
+func LogClientInterceptor(slow time.Duration) ClientInterceptor {
+	return func(address string, request *TenuredCommand, timeout time.Duration, next Invoker) (*TenuredCommand, error) {
+		start := time.Now()
+		response, err := next(address, request, timeout)
+		if used := time.Since(start); slow > 0 && used > slow {
+			logger.Warnf("slow invoke command(%d) %d to %s: %s", request.code, request.id, address, used)
+		} else {
+			logger.Debugf("invoke command(%d) %d to %s: %s, error: %v", request.code, request.id, address, used, err)
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,201 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type orderRecorder struct {
+	lock  sync.Mutex
+	order []string
+}
+
+func (this *orderRecorder) add(name string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.order = append(this.order, name)
+}
+
+func (this *orderRecorder) take() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	order := this.order
+	this.order = nil
+	return order
+}
+
+func TestTenured_Interceptor(t *testing.T) {
+	address := "pipe://tenured-interceptor"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		if string(command.Body) == "panic" {
+			panic("process panic")
+		}
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+
+	serverOrder := &orderRecorder{}
+	recordServer := func(name string) ServerInterceptor {
+		return func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+			serverOrder.add(name + ":before")
+			response, err := next(channel, request)
+			serverOrder.add(name + ":after")
+			return response, err
+		}
+	}
+	server.Use(RecoverInterceptor(), recordServer("first"), recordServer("second"),
+		func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+			if string(request.Body) == "deny" {
+				return nil, NewError("2000", "denied by interceptor")
+			}
+			//观察并修改处理器的响应
+			response, err := next(channel, request)
+			if response != nil && string(request.Body) == "observe" {
+				serverOrder.add("observed:" + string(response.Body))
+				response.Body = []byte("observed")
+			}
+			return response, err
+		})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	clientOrder := &orderRecorder{}
+	client.Use(func(address string, request *TenuredCommand, timeout time.Duration, next Invoker) (*TenuredCommand, error) {
+		clientOrder.add("first")
+		//修改请求
+		if string(request.Body) == "rewrite" {
+			request.Body = []byte("rewritten")
+		}
+		return next(address, request, timeout)
+	}, func(address string, request *TenuredCommand, timeout time.Duration, next Invoker) (*TenuredCommand, error) {
+		clientOrder.add("second")
+		if string(request.Body) == "local" {
+			return nil, NewError("3000", "short circuit")
+		}
+		response, err := next(address, request, timeout)
+		if err == nil && response.IsSuccess() {
+			clientOrder.add("success")
+		}
+		return response, err
+	})
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	invoke := func(body string) (*TenuredCommand, error) {
+		request := NewRequest(HELLO)
+		request.Body = []byte(body)
+		return client.Invoke(address, request, time.Second*3)
+	}
+
+	response, err := invoke("rewrite")
+	assert.Nil(t, err)
+	assert.Equal(t, "rewritten", string(response.Body))
+	assert.Equal(t, []string{"first", "second", "success"}, clientOrder.take())
+	assert.Equal(t, []string{"first:before", "second:before", "second:after", "first:after"}, serverOrder.take())
+
+	//服务端拦截器得到处理器的响应
+	response, err = invoke("observe")
+	assert.Nil(t, err)
+	assert.Equal(t, "observed", string(response.Body))
+	assert.Equal(t, []string{"first:before", "second:before", "observed:observe", "second:after", "first:after"}, serverOrder.take())
+	clientOrder.take()
+
+	//客户端拦截器直接返回
+	_, err = invoke("local")
+	assert.Equal(t, "3000", err.(*TenuredError).Code())
+	assert.Equal(t, []string{"first", "second"}, clientOrder.take())
+	assert.Nil(t, serverOrder.take())
+
+	//服务端拦截器返回错误
+	response, err = invoke("deny")
+	assert.Nil(t, err)
+	assert.Equal(t, "2000", response.GetError().Code())
+	clientOrder.take()
+
+	//处理器panic
+	response, err = invoke("panic")
+	assert.Nil(t, err)
+	assert.Equal(t, "9999", response.GetError().Code())
+
+	//异步调用同样经过拦截器
+	clientOrder.take()
+	done := make(chan *TenuredCommand, 1)
+	request := NewRequest(HELLO)
+	request.Body = []byte("async")
+	client.AsyncInvoke(address, request, time.Second*3, func(response *TenuredCommand, err error) {
+		assert.Nil(t, err)
+		done <- response
+	})
+	select {
+	case response := <-done:
+		assert.Equal(t, "async", string(response.Body))
+	case <-time.After(time.Second * 3):
+		t.Fatal("async invoke timeout")
+	}
+	assert.Equal(t, []string{"first", "second", "success"}, clientOrder.take())
+}
+
+//未认证的channel由内置的认证拦截器拒绝，不执行后面的拦截器
+//记录写出的消息和是否关闭
+type interceptorTestChannel struct {
+	*authTestChannel
+	written []interface{}
+	closed  bool
+}
+
+func (this *interceptorTestChannel) Write(msg interface{}, timeout time.Duration) error {
+	this.written = append(this.written, msg)
+	return nil
+}
+
+func (this *interceptorTestChannel) Close() {
+	this.closed = true
+}
+
+func TestTenuredServer_AuthInterceptor(t *testing.T) {
+	server, err := NewTenuredServer("pipe://tenured-auth-interceptor", nil)
+	assert.Nil(t, err)
+	called := false
+	server.Use(func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+		called = true
+		return next(channel, request)
+	})
+	handler := chainServer(server.interceptors, func(channel remoting.RemotingChannel, request *TenuredCommand) (*TenuredCommand, *TenuredError) {
+		return NewACK(request.id), nil
+	})
+
+	channel := &authTestChannel{attrs: map[string]interface{}{}}
+	_, err2 := handler(channel, NewRequest(HELLO))
+	assert.Equal(t, ErrorNoAuth().Code(), err2.Code())
+	assert.False(t, called)
+
+	//未认证的请求收到错误响应，连接保持
+	written := &interceptorTestChannel{authTestChannel: channel}
+	runner := &tenuredCommandRunner{process: func(channel remoting.RemotingChannel, command *TenuredCommand) {}}
+	request := NewRequest(HELLO)
+	runner.run(written, request, server.interceptors)
+	if assert.Equal(t, 1, len(written.written)) {
+		response := written.written[0].(*TenuredCommand)
+		assert.Equal(t, request.id, response.id)
+		assert.Equal(t, ErrorNoAuth().Code(), response.GetError().Code())
+	}
+	assert.False(t, written.closed)
+
+	auth := NewRequest(REQUEST_CODE_ATUH)
+	_ = auth.SetHeader(&AuthHeader{Module: "test"})
+	assert.Nil(t, server.AuthChecker.Auth(channel, auth))
+	response, err2 := handler(channel, NewRequest(HELLO))
+	assert.Nil(t, err2)
+	assert.NotNil(t, response)
+	assert.True(t, called)
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTenured_InvokeOneway(t *testing.T) {
+	address := "pipe://tenured-oneway"
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	received := make(chan *TenuredCommand, 2)
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		received <- command
+	}, nil)
+	//拦截器返回的错误不会响应单向请求
+	server.Use(func(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+		if string(request.Body) == "deny" {
+			return nil, NewError("2000", "denied by interceptor")
+		}
+		return next(channel, request)
+	})
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	oneways := make(chan bool, 2)
+	client.Use(func(address string, request *TenuredCommand, timeout time.Duration, next Invoker) (*TenuredCommand, error) {
+		oneways <- request.IsOneway()
+		response, err := next(address, request, timeout)
+		assert.Nil(t, response)
+		return response, err
+	})
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	for _, body := range []string{"deny", "typing"} {
+		request := NewRequest(HELLO)
+		request.Body = []byte(body)
+		assert.Nil(t, client.InvokeOneway(address, request, time.Second))
+		assert.True(t, <-oneways)
+		assert.Equal(t, 0, client.responseTables.size())
+	}
+
+	select {
+	case command := <-received:
+		assert.True(t, command.IsOneway())
+		assert.Equal(t, "typing", string(command.Body))
+	case <-time.After(time.Second * 3):
+		t.Fatal("oneway command not received")
+	}
+	assert.Equal(t, 0, len(received))
+}
@@ -0,0 +1,422 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//代理打印消息的方向
+const (
+	PROXY_UPSTREAM   = "->"
+	PROXY_DOWNSTREAM = "<-"
+)
+
+type ProxyOptions struct {
+	//只打印和注入这些请求码的消息，为空时全部
+	Codes []uint16
+
+	//转发请求前等待的时间
+	Latency time.Duration
+
+	//按照此比例不转发请求，直接返回ErrorCode错误，0-1
+	ErrorRate float64
+	ErrorCode string
+
+	//打印body的最大字节数
+	BodyPreview int
+
+	Output io.Writer
+}
+
+func DefaultProxyOptions() *ProxyOptions {
+	return &ProxyOptions{ErrorCode: "9999", BodyPreview: 64, Output: os.Stdout}
+}
+
+//协议调试代理，转发客户端和上游服务之间的消息，并打印解码后的TenuredCommand
+type TenuredProxy struct {
+	address  string
+	upstream string
+	options  *ProxyOptions
+	config   remoting.RemotingConfig
+
+	listener remoting.Listener
+
+	lock    sync.Mutex
+	conns   map[*proxyConn]bool
+	closed  bool
+	outLock sync.Mutex
+	random  *rand.Rand
+}
+
+func NewTenuredProxy(address, upstream string, options *ProxyOptions) *TenuredProxy {
+	if options == nil {
+		options = DefaultProxyOptions()
+	}
+	if options.Output == nil {
+		options.Output = os.Stdout
+	}
+	//不知道两端的帧长度限制，按照分片组装后的最大长度读取
+	config := *remoting.DefaultConfig()
+	config.PacketBytesLimit = config.MaxMessageBytes
+	return &TenuredProxy{
+		address: address, upstream: upstream, options: options,
+		config: config,
+		conns:  map[*proxyConn]bool{},
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (this *TenuredProxy) Start() error {
+	scheme, addr := remoting.ParseAddress(this.address)
+	transport, has := remoting.GetTransport(scheme)
+	if !has {
+		return errors.New("not support transport: " + scheme)
+	}
+	listener, err := transport.Listen(addr)
+	if err != nil {
+		return err
+	}
+	this.listener = listener
+	go this.accept()
+	return nil
+}
+
+func (this *TenuredProxy) accept() {
+	for {
+		conn, err := this.listener.Accept()
+		if err != nil {
+			if this.isClosed() {
+				return
+			}
+			logger.Warnf("proxy accept error: %s", err)
+			continue
+		}
+		go this.serve(conn)
+	}
+}
+
+func (this *TenuredProxy) isClosed() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.closed
+}
+
+func (this *TenuredProxy) serve(client net.Conn) {
+	scheme, addr := remoting.ParseAddress(this.upstream)
+	transport, has := remoting.GetTransport(scheme)
+	if !has {
+		_ = client.Close()
+		logger.Warnf("proxy not support transport: %s", scheme)
+		return
+	}
+	upstream, err := transport.Dial(addr, time.Second*3)
+	if err != nil {
+		_ = client.Close()
+		logger.Warnf("proxy dial %s error: %s", this.upstream, err)
+		return
+	}
+	conn := &proxyConn{
+		proxy: this, client: client, upstream: upstream,
+		requests: map[uint32]uint16{},
+	}
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		conn.close()
+		return
+	}
+	this.conns[conn] = true
+	this.lock.Unlock()
+
+	this.printf("%s connected, upstream %s\n", client.RemoteAddr(), upstream.RemoteAddr())
+	go conn.pump(client, upstream, PROXY_UPSTREAM)
+	conn.pump(upstream, client, PROXY_DOWNSTREAM)
+	conn.close()
+
+	this.lock.Lock()
+	delete(this.conns, conn)
+	this.lock.Unlock()
+	this.printf("%s closed\n", client.RemoteAddr())
+}
+
+func (this *TenuredProxy) Shutdown(interrupt bool) {
+	this.lock.Lock()
+	this.closed = true
+	conns := make([]*proxyConn, 0, len(this.conns))
+	for conn := range this.conns {
+		conns = append(conns, conn)
+	}
+	this.lock.Unlock()
+
+	if this.listener != nil {
+		_ = this.listener.Close()
+	}
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+//是否打印和注入此请求码
+func (this *TenuredProxy) match(code uint16) bool {
+	if len(this.options.Codes) == 0 {
+		return true
+	}
+	for _, c := range this.options.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *TenuredProxy) injectError() bool {
+	if this.options.ErrorRate <= 0 {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.random.Float64() < this.options.ErrorRate
+}
+
+func (this *TenuredProxy) printf(format string, args ...interface{}) {
+	this.outLock.Lock()
+	defer this.outLock.Unlock()
+	_, _ = fmt.Fprintf(this.options.Output, time.Now().Format("15:04:05.000 ")+format, args...)
+}
+
+func (this *TenuredProxy) print(address, direction string, command *TenuredCommand, note string) {
+	flags := "request"
+	if command.IsACK() {
+		flags = "ack"
+	}
+	if command.IsOneway() {
+		flags += "|oneway"
+	}
+	if command.timeout > 0 {
+		flags += fmt.Sprintf("|timeout=%dms", command.timeout)
+	}
+	code := fmt.Sprintf("%d", command.code)
+	if name := commandName(command.code); name != "" && !command.IsACK() {
+		code += "(" + name + ")"
+	}
+	codec := "unknown"
+	if headerCodec, err := headerCodecOf(command.Version); err == nil {
+		codec = headerCodec.Name
+	}
+	body := command.Body
+	if this.options.BodyPreview >= 0 && len(body) > this.options.BodyPreview {
+		body = body[:this.options.BodyPreview]
+	}
+	this.printf("%s %s id=%d code=%s version=%d codec=%s flags=%s header=%s body(%d)=%q%s\n",
+		address, direction, command.id, code, command.Version&^headerCodecMask, codec, flags,
+		headerJSON(command), len(command.Body), body, note)
+}
+
+//header转为JSON显示，其他编码的header先解码
+func headerJSON(command *TenuredCommand) string {
+	if len(command.header) == 0 {
+		return "{}"
+	}
+	headerCodec, err := headerCodecOf(command.Version)
+	if err != nil {
+		return fmt.Sprintf("%q", command.header)
+	}
+	if headerCodec.Id == HEADER_CODEC_JSON {
+		return string(command.header)
+	}
+	var header interface{}
+	if err := headerCodec.Unmarshal(command.header, &header); err != nil {
+		return fmt.Sprintf("%q", command.header)
+	}
+	bs, err := json.Marshal(jsonable(header))
+	if err != nil {
+		return fmt.Sprintf("%q", command.header)
+	}
+	return string(bs)
+}
+
+//msgpack解码的map key为interface{}，转为string才能编码为JSON
+func jsonable(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[fmt.Sprint(k)] = jsonable(item)
+		}
+		return out
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonable(item)
+		}
+	}
+	return v
+}
+
+//代理的一个客户端连接
+type proxyConn struct {
+	proxy    *TenuredProxy
+	client   net.Conn
+	upstream net.Conn
+
+	//注入错误时代理也会写客户端连接
+	clientLock sync.Mutex
+
+	//等待响应的请求码，按照请求码过滤响应
+	requestLock sync.Mutex
+	requests    map[uint32]uint16
+
+	closeOnce sync.Once
+}
+
+func (this *proxyConn) close() {
+	this.closeOnce.Do(func() {
+		_ = this.client.Close()
+		_ = this.upstream.Close()
+	})
+}
+
+func (this *proxyConn) write(to net.Conn, frame []byte) error {
+	if to == this.client {
+		this.clientLock.Lock()
+		defer this.clientLock.Unlock()
+	}
+	_, err := to.Write(frame)
+	return err
+}
+
+//读取完整的帧
+func (this *proxyConn) readFrame(reader io.Reader) ([]byte, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(reader, head); err != nil {
+		return nil, err
+	}
+	length := int(endian.Uint32(head))
+	if length < lengthMin || length > this.proxy.config.PacketBytesLimit {
+		return nil, errors.New(fmt.Sprintf("frame length %d", length))
+	}
+	frame := make([]byte, length)
+	copy(frame, head)
+	if _, err := io.ReadFull(reader, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+//按帧原样转发，解码后的消息只用来打印和注入
+func (this *proxyConn) pump(from, to net.Conn, direction string) {
+	defer this.close()
+	address := this.client.RemoteAddr().String()
+	coder := tenuredCoderFactory(nil, this.proxy.config).(*tenuredCoder)
+	for {
+		frame, err := this.readFrame(from)
+		if err != nil {
+			if err != io.EOF && !this.proxy.isClosed() {
+				this.proxy.printf("%s %s read error: %s\n", address, direction, err)
+			}
+			return
+		}
+		msg, err := coder.Decode(nil, bytes.NewReader(frame))
+		if err != nil {
+			this.proxy.printf("%s %s decode error: %s\n", address, direction, err)
+		} else if command, match := msg.(*TenuredCommand); match && command != nil {
+			fragmented := endian.Uint16(frame[8:]) == REQUEST_CODE_FRAGMENT
+			if this.handle(address, direction, command, fragmented) {
+				continue
+			}
+		}
+		if err := this.write(to, frame); err != nil {
+			return
+		}
+	}
+}
+
+//打印消息并注入延迟或者错误，返回true时消息不再转发
+func (this *proxyConn) handle(address, direction string, command *TenuredCommand, fragmented bool) bool {
+	code := command.code
+	if command.IsACK() {
+		this.requestLock.Lock()
+		code = this.requests[command.id]
+		delete(this.requests, command.id)
+		this.requestLock.Unlock()
+	} else if direction == PROXY_UPSTREAM && !command.IsOneway() {
+		this.requestLock.Lock()
+		this.requests[command.id] = code
+		this.requestLock.Unlock()
+	}
+	if !this.proxy.match(code) {
+		return false
+	}
+	//只对业务请求注入，分片消息已经转发了前面的分片，不能注入错误
+	inject := direction == PROXY_UPSTREAM && !command.IsACK() && !isInternalCode(code)
+	if inject && !fragmented && this.proxy.injectError() {
+		this.proxy.print(address, direction, command, " [injected error]")
+		if !command.IsOneway() {
+			this.requestLock.Lock()
+			delete(this.requests, command.id)
+			this.requestLock.Unlock()
+			this.replyError(command)
+		}
+		return true
+	}
+	note := ""
+	if inject && this.proxy.options.Latency > 0 {
+		note = fmt.Sprintf(" [delayed %s]", this.proxy.options.Latency)
+	}
+	this.proxy.print(address, direction, command, note)
+	if note != "" {
+		time.Sleep(this.proxy.options.Latency)
+	}
+	return false
+}
+
+func (this *proxyConn) replyError(command *TenuredCommand) {
+	response := NewResponse(command).RemotingError(NewError(this.proxy.options.ErrorCode, "injected by tenured proxy"))
+	coder := tenuredCoderFactory(nil, this.proxy.config).(*tenuredCoder)
+	frame, err := coder.Encode(nil, response)
+	if err == nil {
+		err = this.write(this.client, frame)
+	}
+	if err != nil {
+		this.proxy.printf("%s reply injected error: %s\n", this.client.RemoteAddr(), err)
+	} else {
+		this.proxy.print(this.client.RemoteAddr().String(), PROXY_DOWNSTREAM, response, " [injected error]")
+	}
+}
+
+//请求码注册的名称，没有注册时返回空
+func commandName(code uint16) string {
+	commandNamesLock.RLock()
+	defer commandNamesLock.RUnlock()
+	for name, c := range commandNames {
+		if c == code {
+			return name
+		}
+	}
+	return ""
+}
+
+//解析请求码列表，可以使用请求码或者注册的名称，逗号分隔
+func ParseCommands(commands string) ([]uint16, error) {
+	codes := make([]uint16, 0)
+	for _, command := range strings.Split(commands, ",") {
+		if command = strings.TrimSpace(command); command == "" {
+			continue
+		}
+		code, err := ParseCommandCode(command)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
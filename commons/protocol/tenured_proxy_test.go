@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type proxyTestOutput struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (this *proxyTestOutput) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.buf.Write(p)
+}
+
+func (this *proxyTestOutput) String() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.buf.String()
+}
+
+func TestTenuredProxy(t *testing.T) {
+	upstream := "pipe://tenured-proxy-upstream"
+	server, err := NewTenuredServer(upstream, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: upstream, Attributes: map[string]string{}}
+	for _, code := range []uint16{HELLO, HELLO + 1} {
+		server.RegisterCommandProcesser(code, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			response := NewResponse(command)
+			response.Body = command.Body
+			_ = channel.Write(response, time.Second)
+		}, nil)
+	}
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	output := &proxyTestOutput{}
+	proxy := NewTenuredProxy("pipe://tenured-proxy", upstream, &ProxyOptions{
+		Codes: []uint16{HELLO + 1}, Latency: time.Millisecond * 100,
+		ErrorRate: 0, ErrorCode: "2000", BodyPreview: 4, Output: output,
+	})
+	assert.Nil(t, proxy.Start())
+	defer proxy.Shutdown(true)
+
+	errorOutput := &proxyTestOutput{}
+	errorProxy := NewTenuredProxy("pipe://tenured-proxy-error", upstream, &ProxyOptions{
+		ErrorRate: 1, ErrorCode: "2000", Output: errorOutput,
+	})
+	assert.Nil(t, errorProxy.Start())
+	defer errorProxy.Shutdown(true)
+
+	client, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	invoke := func(address string, code uint16) (*TenuredCommand, error) {
+		request := NewRequest(code)
+		assert.Nil(t, request.SetHeader(&AuthHeader{Module: "header"}))
+		request.Body = []byte("hello tenured")
+		return client.Invoke(address, request, time.Second)
+	}
+
+	response, err := invoke("pipe://tenured-proxy", HELLO)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello tenured", string(response.Body))
+	//只打印过滤的请求码
+	assert.False(t, strings.Contains(output.String(), "code=2 "))
+
+	startTime := time.Now()
+	response, err = invoke("pipe://tenured-proxy", HELLO+1)
+	assert.Nil(t, err)
+	assert.True(t, response.IsSuccess())
+	assert.True(t, time.Since(startTime) >= time.Millisecond*100)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	request, ack := lines[len(lines)-2], lines[len(lines)-1]
+	assert.True(t, strings.Contains(request, "-> id="), request)
+	assert.True(t, strings.Contains(request, "flags=request|timeout=1000ms"), request)
+	assert.True(t, strings.Contains(request, `"module":"header"`), request)
+	assert.True(t, strings.Contains(request, `body(13)="hell"`), request)
+	assert.True(t, strings.Contains(request, "[delayed 100ms]"), request)
+	assert.True(t, strings.Contains(ack, "<- id="), ack)
+	assert.True(t, strings.Contains(ack, "flags=ack"), ack)
+
+	//注入错误，认证请求正常转发
+	response, err = invoke("pipe://tenured-proxy-error", HELLO)
+	assert.Nil(t, err)
+	assert.Equal(t, "2000", response.GetError().Code())
+	assert.True(t, strings.Contains(errorOutput.String(), "[injected error]"))
+}
+
+func TestParseCommands(t *testing.T) {
+	RegisterCommandName("TestService.Proxy", uint16(9101))
+	codes, err := ParseCommands("TestService.Proxy, 2001,")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{9101, 2001}, codes)
+	assert.Equal(t, "TestService.Proxy", commandName(9101))
+	_, err = ParseCommands("TestService.Unknown")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,157 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ReplayOptions struct {
+	//回放速度，1为原始速度，2为两倍速度，0不等待依次尽快发送
+	Speed float64
+
+	//等待响应的时间
+	Timeout time.Duration
+}
+
+//回放的响应和抓包中的响应不一致
+type ReplayMismatch struct {
+	Request  *CaptureRecord
+	Expected *CaptureRecord
+	Actual   *TenuredCommand
+	Err      error
+
+	index int
+}
+
+func (this *ReplayMismatch) String() string {
+	if this.Err != nil {
+		return fmt.Sprintf("request id=%d code=%d error: %s", this.Request.Id, this.Request.Code, this.Err)
+	}
+	return fmt.Sprintf("request id=%d code=%d expected code=%d header=%s body=%q, actual code=%d header=%s body=%q",
+		this.Request.Id, this.Request.Code,
+		this.Expected.Code, string(this.Expected.Header), this.Expected.Body,
+		this.Actual.code, string(this.Actual.header), this.Actual.Body)
+}
+
+type ReplayReport struct {
+	//回放的请求数
+	Requests int
+
+	//响应一致的请求数
+	Matched int
+
+	//抓包中没有响应的请求数，单向请求或者抓包时没有返回
+	Unverified int
+
+	Mismatches []*ReplayMismatch
+}
+
+//是否需要回放，心跳、认证等协议内部的请求不回放
+func replayable(record *CaptureRecord) bool {
+	return record.Direction == CAPTURE_IN && !record.IsACK() && !isInternalCode(record.Code)
+}
+
+//找到每个请求在抓包中对应的响应
+func expectedResponses(records []*CaptureRecord) map[*CaptureRecord]*CaptureRecord {
+	type requestKey struct {
+		address string
+		id      uint32
+	}
+	pending := map[requestKey]*CaptureRecord{}
+	expected := map[*CaptureRecord]*CaptureRecord{}
+	for _, record := range records {
+		key := requestKey{address: record.Address, id: record.Id}
+		if replayable(record) && !record.IsOneway() {
+			pending[key] = record
+		} else if record.Direction == CAPTURE_OUT && record.IsACK() {
+			if request, has := pending[key]; has {
+				expected[request] = record
+				delete(pending, key)
+			}
+		}
+	}
+	return expected
+}
+
+//把抓包中收到的请求重新发送到address，并和抓包中的响应比较
+func Replay(client *TenuredClient, address string, records []*CaptureRecord, options *ReplayOptions) *ReplayReport {
+	if options == nil {
+		options = &ReplayOptions{Speed: 1}
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = time.Second * 3
+	}
+	expected := expectedResponses(records)
+	report := &ReplayReport{Mismatches: make([]*ReplayMismatch, 0)}
+	lock := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+
+	var firstTime time.Time
+	startTime := time.Now()
+	for index, record := range records {
+		if !replayable(record) {
+			continue
+		}
+		report.Requests++
+		if firstTime.IsZero() {
+			firstTime = record.Time
+		}
+		if options.Speed > 0 {
+			offset := time.Duration(float64(record.Time.Sub(firstTime)) / options.Speed)
+			if wait := offset - time.Since(startTime); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		replay := func(index int, request *CaptureRecord) {
+			mismatch := replayRequest(client, address, request, expected[request], timeout)
+			lock.Lock()
+			defer lock.Unlock()
+			if mismatch != nil {
+				mismatch.index = index
+				report.Mismatches = append(report.Mismatches, mismatch)
+			} else if expected[request] == nil {
+				report.Unverified++
+			} else {
+				report.Matched++
+			}
+		}
+		if options.Speed > 0 {
+			wg.Add(1)
+			go func(index int, request *CaptureRecord) {
+				defer wg.Done()
+				replay(index, request)
+			}(index, record)
+		} else {
+			replay(index, record)
+		}
+	}
+	wg.Wait()
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].index < report.Mismatches[j].index
+	})
+	return report
+}
+
+func replayRequest(client *TenuredClient, address string, request, expected *CaptureRecord, timeout time.Duration) *ReplayMismatch {
+	command := request.Request()
+	if request.IsOneway() {
+		if err := client.InvokeOneway(address, command, timeout); err != nil {
+			return &ReplayMismatch{Request: request, Err: err}
+		}
+		return nil
+	}
+	response, err := client.Invoke(address, command, timeout)
+	if err != nil {
+		return &ReplayMismatch{Request: request, Expected: expected, Err: err}
+	}
+	if expected != nil && (response.code != expected.Code ||
+		!bytes.Equal(response.header, expected.Header) || !bytes.Equal(response.Body, expected.Body)) {
+		return &ReplayMismatch{Request: request, Expected: expected, Actual: response}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"errors"
+	"github.com/ihaiker/tenured-go-server/commons/future"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sync"
+)
+
+const responseTableShards = 64
+
+//请求ID在整个进程内递增并且会溢出重用，所以等待的响应按照 channel+请求ID 区分
+type responseKey struct {
+	channel remoting.RemotingChannel
+	id      uint32
+}
+
+type responseTableBlock struct {
+	id      uint32
+	address string
+	channel remoting.RemotingChannel
+	future  *future.SetFuture
+}
+
+type responseTableShard struct {
+	lock   sync.Mutex
+	blocks map[responseKey]*responseTableBlock
+}
+
+//等待响应的请求表，按请求ID分片加锁
+type responseTable struct {
+	shards [responseTableShards]*responseTableShard
+}
+
+func newResponseTable() *responseTable {
+	table := &responseTable{}
+	for i := range table.shards {
+		table.shards[i] = &responseTableShard{blocks: map[responseKey]*responseTableBlock{}}
+	}
+	return table
+}
+
+func (this *responseTable) shard(id uint32) *responseTableShard {
+	return this.shards[id%responseTableShards]
+}
+
+//添加等待的请求，channel上已经有相同ID的请求在等待时返回错误
+func (this *responseTable) put(channel remoting.RemotingChannel, id uint32) (*responseTableBlock, error) {
+	key := responseKey{channel: channel, id: id}
+	shard := this.shard(id)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if _, has := shard.blocks[key]; has {
+		return nil, errors.New("request id conflict")
+	}
+	block := &responseTableBlock{id: id, address: channel.RemoteAddr(), channel: channel, future: future.Set()}
+	shard.blocks[key] = block
+	return block, nil
+}
+
+func (this *responseTable) get(channel remoting.RemotingChannel, id uint32) (*responseTableBlock, bool) {
+	shard := this.shard(id)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	block, has := shard.blocks[responseKey{channel: channel, id: id}]
+	return block, has
+}
+
+//删除等待的请求，只删除block本身，防止删除重用ID的新请求
+func (this *responseTable) remove(id uint32, block *responseTableBlock) {
+	key := responseKey{channel: block.channel, id: id}
+	shard := this.shard(id)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if shard.blocks[key] == block {
+		delete(shard.blocks, key)
+	}
+}
+
+//遍历所有等待的请求，filter为空时遍历全部
+func (this *responseTable) blocks(filter func(block *responseTableBlock) bool) []*responseTableBlock {
+	blocks := make([]*responseTableBlock, 0)
+	for _, shard := range this.shards {
+		shard.lock.Lock()
+		for _, block := range shard.blocks {
+			if filter == nil || filter(block) {
+				blocks = append(blocks, block)
+			}
+		}
+		shard.lock.Unlock()
+	}
+	return blocks
+}
+
+func (this *responseTable) size() int {
+	size := 0
+	for _, shard := range this.shards {
+		shard.lock.Lock()
+		size += len(shard.blocks)
+		shard.lock.Unlock()
+	}
+	return size
+}
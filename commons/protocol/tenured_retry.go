@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/future"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"net"
+	"time"
+)
+
+//幂等方法调用失败时的重试策略
+type RetryPolicy struct {
+	//最多调用次数，包括第一次调用，小于等于1时不重试
+	MaxAttempts int
+
+	//第一次重试前等待的时间，之后每次翻倍，不超过MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	//可以重试的服务端错误码
+	RetryableCodes []string
+
+	//可以重试的通讯错误类型，建立连接失败总是可以重试
+	RetryableErrors []remoting.ErrorType
+
+	//调用超时是否重试，超时的请求服务端可能已经执行
+	RetryTimeout bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond * 50,
+		MaxBackoff:  time.Second,
+		//没有可用的路由，实例已熔断，请求都没有被处理
+		RetryableCodes: []string{"1005", "1008"},
+		RetryableErrors: []remoting.ErrorType{
+			remoting.ErrClosed, remoting.ErrNoChannel, remoting.ErrUnavailable,
+		},
+	}
+}
+
+//错误是否可以重试，建立连接失败和通讯错误都可以重试
+func (this *RetryPolicy) Retryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *remoting.RemotingError:
+		return remoting.IsRemotingError(e, this.RetryableErrors...)
+	case *net.OpError:
+		return e.Op == "dial"
+	case *TenuredError:
+		for _, code := range this.RetryableCodes {
+			if e.Is(code) {
+				return true
+			}
+		}
+		return false
+	}
+	return err == future.ErrTimeout && this.RetryTimeout
+}
+
+//第attempt次重试前等待的时间，attempt从1开始
+func (this *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := this.Backoff
+	for i := 1; i < attempt && backoff > 0; i++ {
+		if backoff *= 2; this.MaxBackoff > 0 && backoff >= this.MaxBackoff {
+			return this.MaxBackoff
+		}
+	}
+	return backoff
+}
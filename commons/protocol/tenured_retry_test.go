@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"errors"
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//每次选择都返回全部实例
+type retryTestLoadBalance struct {
+	instances []*registry.ServerInstance
+	returns   int
+}
+
+func (this *retryTestLoadBalance) Select(obj ...interface{}) ([]*registry.ServerInstance, string, error) {
+	return this.instances, "", nil
+}
+
+func (this *retryTestLoadBalance) Return(regKey string) {
+	this.returns++
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	assert.True(t, policy.Retryable(ErrorRouter()))
+	assert.False(t, policy.Retryable(NewError("2001", "exists")))
+	assert.False(t, policy.Retryable(ErrorDB(errors.New("duplicate key"))))
+	assert.True(t, policy.Retryable(&remoting.RemotingError{Op: remoting.ErrClosed}))
+	assert.False(t, policy.Retryable(&remoting.RemotingError{Op: remoting.ErrEncoder}))
+	pipe, _ := remoting.GetTransport("pipe")
+	_, err := pipe.Dial("tenured-retry-none", time.Second)
+	assert.True(t, policy.Retryable(err))
+
+	assert.Equal(t, time.Millisecond*50, policy.backoff(1))
+	assert.Equal(t, time.Millisecond*200, policy.backoff(3))
+	assert.Equal(t, time.Second, policy.backoff(10))
+}
+
+func TestTenuredClientInvoke_InvokeRetry(t *testing.T) {
+	calls := map[string]*int32{}
+	for _, address := range []string{"pipe://tenured-retry-a", "pipe://tenured-retry-b"} {
+		address, count := address, new(int32)
+		calls[address] = count
+		server, err := NewTenuredServer(address, nil)
+		assert.Nil(t, err)
+		server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+		server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			atomic.AddInt32(count, 1)
+			response := NewResponse(command)
+			if address == "pipe://tenured-retry-a" {
+				response.Error(string(command.Body), "failed at "+address)
+			} else {
+				response.Body = []byte(address)
+			}
+			_ = channel.Write(response, time.Second)
+		}, nil)
+		assert.Nil(t, server.Start())
+		defer server.Shutdown(true)
+	}
+
+	invoke := NewClientInvoke(nil)
+	invoke.SetIdentity("test", "")
+	policy := DefaultRetryPolicy()
+	policy.Backoff = time.Millisecond
+	invoke.SetRetryPolicy(policy)
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+
+	lb := &retryTestLoadBalance{instances: []*registry.ServerInstance{
+		{Address: "pipe://tenured-retry-down", Status: "OK"},
+		{Address: "pipe://tenured-retry-a", Status: "OK"},
+		{Address: "pipe://tenured-retry-b", Status: "OK"},
+	}}
+
+	//连接失败、可重试的错误码都换到下一个实例
+	body, err := invoke.InvokeRetry(lb, nil, HELLO, nil, []byte("1005"), time.Second, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "pipe://tenured-retry-b", string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls["pipe://tenured-retry-a"]))
+	assert.Equal(t, 3, lb.returns)
+
+	//不可重试的错误码
+	lb.instances = lb.instances[1:]
+	_, err = invoke.InvokeRetry(lb, nil, HELLO, nil, []byte("2001"), time.Second, nil)
+	assert.Equal(t, "2001", err.Code())
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls["pipe://tenured-retry-a"]))
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls["pipe://tenured-retry-b"]))
+
+	//没有重试策略只调用一次
+	invoke.SetRetryPolicy(nil)
+	_, err = invoke.InvokeRetry(lb, nil, HELLO, nil, []byte("1005"), time.Second, nil)
+	assert.Equal(t, "1005", err.Code())
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls["pipe://tenured-retry-b"]))
+}
+
+//调用过程中实例停止，连接关闭的错误可以重试到其他实例
+func TestTenuredClientInvoke_InvokeRetryClosed(t *testing.T) {
+	down := "pipe://tenured-retry-killed"
+	killed, err := NewTenuredServer(down, nil)
+	assert.Nil(t, err)
+	killed.AuthHeader = &AuthHeader{Module: "test", Address: down, Attributes: map[string]string{}}
+	killed.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		go killed.Shutdown(true)
+	}, nil)
+	assert.Nil(t, killed.Start())
+	defer killed.Shutdown(true)
+
+	up := "pipe://tenured-retry-alive"
+	alive, err := NewTenuredServer(up, nil)
+	assert.Nil(t, err)
+	alive.AuthHeader = &AuthHeader{Module: "test", Address: up, Attributes: map[string]string{}}
+	alive.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		response := NewResponse(command)
+		response.Body = []byte(up)
+		_ = channel.Write(response, time.Second)
+	}, nil)
+	assert.Nil(t, alive.Start())
+	defer alive.Shutdown(true)
+
+	invoke := NewClientInvoke(nil)
+	invoke.SetIdentity("test", "")
+	invoke.SetRetryPolicy(DefaultRetryPolicy())
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+
+	lb := &retryTestLoadBalance{instances: []*registry.ServerInstance{
+		{Address: down, Status: "OK"}, {Address: up, Status: "OK"},
+	}}
+	startTime := time.Now()
+	body, invokeErr := invoke.InvokeRetry(lb, nil, HELLO, nil, nil, time.Second*5, nil)
+	assert.Nil(t, invokeErr)
+	assert.Equal(t, up, string(body))
+	assert.True(t, time.Since(startTime) < time.Second*5)
+}
+
+//所有重试共用调用的超时时间
+func TestTenuredClientInvoke_InvokeRetryDeadline(t *testing.T) {
+	addresses := []string{"pipe://tenured-retry-slow-a", "pipe://tenured-retry-slow-b", "pipe://tenured-retry-slow-c"}
+	instances := make([]*registry.ServerInstance, 0, len(addresses))
+	for _, address := range addresses {
+		server, err := NewTenuredServer(address, nil)
+		assert.Nil(t, err)
+		server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+		server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+			time.Sleep(time.Second)
+		}, nil)
+		assert.Nil(t, server.Start())
+		defer server.Shutdown(true)
+		instances = append(instances, &registry.ServerInstance{Address: address, Status: "OK"})
+	}
+
+	invoke := NewClientInvoke(nil)
+	invoke.SetIdentity("test", "")
+	policy := DefaultRetryPolicy()
+	policy.Backoff, policy.RetryTimeout = time.Millisecond, true
+	invoke.SetRetryPolicy(policy)
+	assert.Nil(t, invoke.Start())
+	defer invoke.Shutdown(true)
+
+	startTime := time.Now()
+	_, err := invoke.InvokeRetry(&retryTestLoadBalance{instances: instances}, nil, HELLO, nil, nil, time.Millisecond*300, nil)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(startTime) < time.Millisecond*600)
+}
@@ -2,12 +2,17 @@ package protocol
 
 import (
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"sync/atomic"
+	"time"
 )
 
 type TenuredServer struct {
 	tenuredService
 	AuthChecker TenuredAuthChecker
 	*AuthHeader
+
+	//收发消息抓包，StartCapture开启
+	capture captureSwitch
 }
 
 func (this *TenuredServer) onCommandProcesser(channel remoting.RemotingChannel, command *TenuredCommand) {
@@ -17,24 +22,57 @@ func (this *TenuredServer) onCommandProcesser(channel remoting.RemotingChannel,
 			this.makeAck(channel, command, nil, ErrorInvalidAuth())
 		} else {
 			logger.Debugf("channel(%s) auth success", channel.RemoteAddr())
-			this.makeAck(channel, command, this.AuthHeader, nil)
+			this.makeAck(channel, command, this.authResponse(channel, command), nil)
 		}
 		return
-	} else if this.AuthChecker != nil && !this.AuthChecker.IsAuthed(channel) {
-		this.makeAck(channel, command, nil, ErrorNoAuth())
-		this.fastFailChannel(channel)
-		return
 	}
 	this.tenuredService.onCommandProcesser(channel, command)
 }
 
+//内置的认证拦截器，总是第一个执行，未认证的channel不能调用请求处理器，
+//错误和其他拦截器返回的错误一样经过拦截器链返回给客户端，不关闭连接
+func (this *TenuredServer) authInterceptor(channel remoting.RemotingChannel, request *TenuredCommand, next ServerHandler) (*TenuredCommand, *TenuredError) {
+	if this.AuthChecker != nil && !this.AuthChecker.IsAuthed(channel) {
+		return nil, ErrorNoAuth()
+	}
+	return next(channel, request)
+}
+
+//返回给客户端的认证信息，并协商压缩算法、header编码和请求剩余等待时间
+func (this *TenuredServer) authResponse(channel remoting.RemotingChannel, command *TenuredCommand) *AuthHeader {
+	coder := coderOf(channel)
+	request := &AuthHeader{}
+	if coder == nil || command.GetHeader(request) != nil || request.Attributes == nil {
+		return this.AuthHeader
+	}
+	response := this.AuthHeader
+	if compressor := negotiateCompress(coder.config.Compress, request.Attributes[AUTH_ATTRIBUTE_COMPRESS]); compressor != nil {
+		logger.Debugf("channel(%s) use compressor %s", channel.RemoteAddr(), compressor.Name)
+		coder.setCompressor(compressor)
+		response = response.clone()
+		response.Attributes[AUTH_ATTRIBUTE_COMPRESS] = compressor.Name
+	}
+	if headerCodec := negotiateHeaderCodec(coder.config.HeaderCodec, request.Attributes[AUTH_ATTRIBUTE_HEADER_CODEC]); headerCodec != nil {
+		logger.Debugf("channel(%s) use header codec %s", channel.RemoteAddr(), headerCodec.Name)
+		if response == this.AuthHeader {
+			response = response.clone()
+		}
+		response.Attributes[AUTH_ATTRIBUTE_HEADER_CODEC] = headerCodec.Name
+	}
+	if request.Attributes[AUTH_ATTRIBUTE_DEADLINE] == "true" {
+		coder.setDeadline(true)
+		if response == this.AuthHeader {
+			response = response.clone()
+		}
+		response.Attributes[AUTH_ATTRIBUTE_DEADLINE] = "true"
+	}
+	return response
+}
+
 func (this *TenuredServer) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
 	command := msg.(*TenuredCommand)
 	if command.IsACK() {
-		requestId := command.id
-		if f, has := this.responseTables[requestId]; has {
-			f.future.Set(command)
-		}
+		this.onResponse(channel, command)
 		return
 	} else {
 		this.onCommandProcesser(channel, command)
@@ -48,16 +86,41 @@ func NewTenuredServer(address string, config *remoting.RemotingConfig) (*Tenured
 	if remotingServer, err := remoting.NewRemotingServer(address, config); err != nil {
 		return nil, err
 	} else {
-		remotingServer.SetCoder(&tenuredCoder{config: config})
 		server := &TenuredServer{
 			tenuredService: tenuredService{
 				remoting:         remotingServer,
-				responseTables:   map[uint32]*responseTableBlock{},
+				responseTables:   newResponseTable(),
 				commandProcesser: map[uint16]*tenuredCommandRunner{},
 			},
 			AuthChecker: &ModuleAuthChecker{},
 		}
+		server.interceptors = []ServerInterceptor{server.authInterceptor}
+		remotingServer.SetCoderFactory(func(channel remoting.RemotingChannel, config remoting.RemotingConfig) remoting.RemotingCoder {
+			coder := tenuredCoderFactory(channel, config).(*tenuredCoder)
+			coder.capture = &server.capture
+			return coder
+		})
 		remotingServer.SetHandler(server)
 		return server, nil
 	}
 }
+
+//注册请求处理拦截器，在内置的认证拦截器之后按照注册顺序执行，需要在Start之前注册
+func (this *TenuredServer) Use(interceptors ...ServerInterceptor) {
+	this.interceptors = append(this.interceptors, interceptors...)
+}
+
+//正常关闭时先通知所有客户端(GOAWAY)，并等待正在处理的请求完成
+func (this *TenuredServer) Shutdown(interrupt bool) {
+	if server, match := this.remoting.(*remoting.RemotingServer); match && !interrupt {
+		server.Drain(func(channel remoting.RemotingChannel) {
+			if err := channel.Write(NewGoaway(), time.Second); err != nil {
+				logger.Debugf("send goaway to %s error: %s", channel.RemoteAddr(), err)
+			}
+		}, func() bool {
+			return atomic.LoadInt32(&this.processing) == 0
+		})
+	}
+	this.tenuredService.Shutdown(interrupt)
+	this.StopCapture()
+}
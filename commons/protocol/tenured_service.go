@@ -1,20 +1,18 @@
 package protocol
 
 import (
+	"context"
 	"errors"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"github.com/ihaiker/tenured-go-server/commons/executors"
 	"github.com/ihaiker/tenured-go-server/commons/future"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type responseTableBlock struct {
-	address string
-	future  *future.SetFuture
-}
-
 type TenuredService interface {
 	commons.Service
 
@@ -23,6 +21,9 @@ type TenuredService interface {
 	AsyncInvoke(channel string, command *TenuredCommand, timeout time.Duration,
 		callback func(tenuredCommand *TenuredCommand, err error))
 
+	//单向请求，写出后直接返回，服务端不返回响应
+	InvokeOneway(channel string, command *TenuredCommand, timeout time.Duration) error
+
 	RegisterCommandProcesser(code uint16, processer TenuredCommandProcesser, executorService executors.ExecutorService)
 
 	IsActive() bool
@@ -30,27 +31,54 @@ type TenuredService interface {
 
 type tenuredService struct {
 	remoting         remoting.Remoting
-	responseTables   map[uint32]*responseTableBlock
+	responseTables   *responseTable
 	commandProcesser map[uint16]*tenuredCommandRunner
 	*remoting.HandlerWrapper
+
+	//正在处理的请求数量
+	processing int32
+
+	//正在处理的请求取消方法，收到取消请求或者channel关闭时取消
+	runningLock sync.Mutex
+	running     map[responseKey]context.CancelFunc
+
+	//请求处理拦截器
+	interceptors []ServerInterceptor
+
+	//channel的心跳统计
+	healthLock sync.RWMutex
+	healths    map[remoting.RemotingChannel]*channelHealth
 }
 
 func (this *tenuredService) Invoke(channel string, command *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
 	if !this.remoting.IsActive() {
-		return nil, &TenuredError{code: remoting.ErrClosed.String(), message: "closed"}
+		return nil, closedError("the service is closed")
+	}
+	if remotingChannel, err := this.remoting.GetChannel(channel, timeout); err != nil {
+		logger.Debugf("send %d error: %v", command.id, err)
+		return nil, err
+	} else {
+		return this.invokeChannel(remotingChannel, command, timeout)
 	}
+}
+
+//在指定的channel上发送请求并等待响应
+func (this *tenuredService) invokeChannel(channel remoting.RemotingChannel, command *TenuredCommand, timeout time.Duration) (*TenuredCommand, error) {
 	requestId := command.id
-	responseFuture := future.Set()
-	this.responseTables[requestId] = &responseTableBlock{address: channel, future: responseFuture}
+	block, err := this.responseTables.put(channel, requestId)
+	if err != nil {
+		return nil, err
+	}
+	defer this.responseTables.remove(requestId, block)
 
-	if err := this.remoting.SendTo(channel, command, timeout); err != nil {
+	withTimeout(command, timeout)
+	if err := channel.Write(command, timeout); err != nil {
 		logger.Debugf("send %d error: %v", requestId, err)
-		delete(this.responseTables, requestId)
 		return nil, err
 	} else {
-		response, err := responseFuture.GetWithTimeout(timeout)
-		delete(this.responseTables, requestId)
+		response, err := block.future.GetWithTimeout(timeout)
 		if err != nil {
+			this.cancelRemote(channel, requestId, err)
 			return nil, err
 		}
 		if responseCommand, match := response.(*TenuredCommand); !match {
@@ -65,29 +93,36 @@ func (this *tenuredService) AsyncInvoke(channel string, command *TenuredCommand,
 	callback func(tenuredCommand *TenuredCommand, err error)) {
 
 	if !this.remoting.IsActive() {
-		callback(nil, &TenuredError{code: remoting.ErrClosed.String(), message: "closed"})
+		callback(nil, closedError("the service is closed"))
+		return
+	}
+	remotingChannel, err := this.remoting.GetChannel(channel, timeout)
+	if err != nil {
+		callback(nil, err)
 		return
 	}
 	requestId := command.id
-	responseFuture := future.Set()
-	this.responseTables[requestId] = &responseTableBlock{address: channel, future: responseFuture}
+	block, err := this.responseTables.put(remotingChannel, requestId)
+	if err != nil {
+		callback(nil, err)
+		return
+	}
 
-	this.remoting.SyncSendTo(channel, command, timeout, func(err error) {
+	withTimeout(command, timeout)
+	remotingChannel.AsyncWrite(command, timeout, func(err error) {
 		if err != nil {
-			logger.Debugf("async send %d error", requestId)
-			callback(nil, err)
-			delete(this.responseTables, requestId)
-		} else {
-			logger.Debugf("async send %d error", requestId)
+			logger.Debugf("async send %d error: %v", requestId, err)
+			block.future.Exception(err)
 		}
 	})
 
 	//TODO 设置异步执行可调用携程管理
 	go func() {
-		response, err := responseFuture.GetWithTimeout(timeout)
-		delete(this.responseTables, requestId)
+		response, err := block.future.GetWithTimeout(timeout)
+		this.responseTables.remove(requestId, block)
 
 		if err != nil {
+			this.cancelRemote(remotingChannel, requestId, err)
 			callback(nil, err)
 			return
 		}
@@ -100,12 +135,71 @@ func (this *tenuredService) AsyncInvoke(channel string, command *TenuredCommand,
 	}()
 }
 
+func (this *tenuredService) InvokeOneway(channel string, command *TenuredCommand, timeout time.Duration) error {
+	if !this.remoting.IsActive() {
+		return closedError("the service is closed")
+	}
+	remotingChannel, err := this.remoting.GetChannel(channel, timeout)
+	if err != nil {
+		return err
+	}
+	command.MakeOneway()
+	withTimeout(command, timeout)
+	if err := remotingChannel.Write(command, timeout); err != nil {
+		logger.Debugf("send oneway %d error: %v", command.id, err)
+		return err
+	}
+	return nil
+}
+
+//服务或者连接已经关闭，和连接写入失败使用同样的错误类型，调用方可以按通讯错误重试
+func closedError(message string) error {
+	return &remoting.RemotingError{Op: remoting.ErrClosed, Err: errors.New(message)}
+}
+
+//请求携带等待时间，调用方设置了更短的时间时不修改
+func withTimeout(command *TenuredCommand, timeout time.Duration) {
+	if timeout > 0 && (command.timeout == 0 || command.Timeout() > timeout) {
+		command.SetTimeout(timeout)
+	}
+}
+
+//等待响应超时，通知服务端取消请求的处理
+func (this *tenuredService) cancelRemote(channel remoting.RemotingChannel, requestId uint32, err error) {
+	if err != future.ErrTimeout {
+		return
+	}
+	this.sendCancel(channel, requestId)
+}
+
+func (this *tenuredService) sendCancel(channel remoting.RemotingChannel, requestId uint32) {
+	channel.AsyncWrite(NewCancel(requestId), time.Second, func(err error) {
+		if err != nil {
+			logger.Debugf("send cancel %d error: %v", requestId, err)
+		}
+	})
+}
+
+//放弃等待中的请求，等待的调用返回ErrorCanceled，并通知服务端取消处理
+func (this *tenuredService) Cancel(command *TenuredCommand) bool {
+	canceled := false
+	for _, block := range this.responseTables.blocks(func(block *responseTableBlock) bool {
+		return block.id == command.id
+	}) {
+		if block.future.Exception(ErrorCanceled(context.Canceled)) {
+			this.sendCancel(block.channel, block.id)
+			canceled = true
+		}
+	}
+	return canceled
+}
+
 func (this *tenuredService) RegisterCommandProcesser(code uint16, processer TenuredCommandProcesser, executorService executors.ExecutorService) {
 	this.commandProcesser[code] = &tenuredCommandRunner{process: processer, executorService: executorService}
 }
 
 func (this *tenuredService) makeAck(channel remoting.RemotingChannel, requestCommand *TenuredCommand, header interface{}, err *TenuredError) {
-	response := NewACK(requestCommand.id)
+	response := NewResponse(requestCommand)
 	if err != nil {
 		response.RemotingError(err)
 	}
@@ -123,31 +217,109 @@ func (this *tenuredService) makeAck(channel remoting.RemotingChannel, requestCom
 func (this *tenuredService) onCommandProcesser(channel remoting.RemotingChannel, command *TenuredCommand) {
 	if command.code == REQUEST_CODE_IDLE {
 		logger.Debug("receiver idle ", channel.RemoteAddr())
-		this.makeAck(channel, command, nil, nil)
+		//原样返回心跳携带的发送时间
+		ack := NewACK(command.id)
+		ack.Body = command.Body
+		if err := channel.Write(ack, time.Second*7); err != nil && !remoting.IsRemotingError(err, remoting.ErrClosed) {
+			logger.Warnf("send idle ack error: %s", err.Error())
+		}
+		return
+	} else if command.code == REQUEST_CODE_CANCEL {
+		this.cancelRunning(channel, command.id)
 		return
 	} else if processRunner, has := this.commandProcesser[command.code]; has {
-		processRunner.onCommand(channel, command)
+		atomic.AddInt32(&this.processing, 1)
+		key := this.startRunning(channel, command)
+		processRunner.onCommand(channel, command, this.interceptors, func() {
+			this.stopRunning(key)
+			atomic.AddInt32(&this.processing, -1)
+		})
 	} else {
 		logger.Warn("not found process: ", command.code)
 	}
 }
 
+//设置请求处理的Context，请求方设置了等待时间时使用此时间作为截止时间
+func (this *tenuredService) startRunning(channel remoting.RemotingChannel, command *TenuredCommand) responseKey {
+	var cancel context.CancelFunc
+	if command.timeout > 0 {
+		command.ctx, cancel = context.WithTimeout(context.Background(), command.Timeout())
+	} else {
+		command.ctx, cancel = context.WithCancel(context.Background())
+	}
+	key := responseKey{channel: channel, id: command.id}
+	this.runningLock.Lock()
+	defer this.runningLock.Unlock()
+	if this.running == nil {
+		this.running = map[responseKey]context.CancelFunc{}
+	}
+	if previous, has := this.running[key]; has {
+		//相同ID的请求重复发送，之前的请求不再会有响应
+		previous()
+	}
+	this.running[key] = cancel
+	return key
+}
+
+func (this *tenuredService) stopRunning(key responseKey) {
+	this.runningLock.Lock()
+	cancel, has := this.running[key]
+	delete(this.running, key)
+	this.runningLock.Unlock()
+	if has {
+		cancel()
+	}
+}
+
+func (this *tenuredService) cancelRunning(channel remoting.RemotingChannel, id uint32) {
+	this.runningLock.Lock()
+	cancel, has := this.running[responseKey{channel: channel, id: id}]
+	this.runningLock.Unlock()
+	if has {
+		logger.Debugf("cancel request %d from %s", id, channel.RemoteAddr())
+		cancel()
+	}
+}
+
+//channel关闭后取消此channel上所有正在处理的请求
+func (this *tenuredService) cancelChannel(channel remoting.RemotingChannel) {
+	this.runningLock.Lock()
+	cancels := make([]context.CancelFunc, 0)
+	for key, cancel := range this.running {
+		if key.channel == channel {
+			cancels = append(cancels, cancel)
+		}
+	}
+	this.runningLock.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
 func (this *tenuredService) OnMessage(channel remoting.RemotingChannel, msg interface{}) {
 	command := msg.(*TenuredCommand)
 	if command.IsACK() {
-		requestId := command.id
-		if f, has := this.responseTables[requestId]; has {
-			f.future.Set(command)
-		}
+		this.onResponse(channel, command)
 		return
 	} else {
 		this.onCommandProcesser(channel, command)
 	}
 }
 
-//发送心跳包
+func (this *tenuredService) onResponse(channel remoting.RemotingChannel, command *TenuredCommand) {
+	if block, has := this.responseTables.get(channel, command.id); has {
+		block.future.Set(command)
+	} else {
+		this.onIdleAck(channel, command)
+	}
+}
+
+//发送心跳包，携带发送时间用于计算往返时间
 func (this *tenuredService) OnIdle(channel remoting.RemotingChannel) {
-	if err := channel.Write(NewIdle(), time.Second*3); err != nil {
+	idle := NewIdle()
+	idle.Body = commons.UInt64(uint64(time.Now().UnixNano()))
+	this.healthOf(channel).ping(idle.id)
+	if err := channel.Write(idle, time.Second*3); err != nil {
 		if remoting.IsRemotingError(err, remoting.ErrClosed) {
 			return
 		}
@@ -157,13 +329,15 @@ func (this *tenuredService) OnIdle(channel remoting.RemotingChannel) {
 
 func (this *tenuredService) OnClose(channel remoting.RemotingChannel) {
 	this.fastFailChannel(channel)
+	this.cancelChannel(channel)
+	this.removeHealth(channel)
 }
 
 func (this *tenuredService) fastFailChannel(channel remoting.RemotingChannel) {
-	for _, v := range this.responseTables {
-		if v.address == channel.RemoteAddr() {
-			v.future.Exception(errors.New(remoting.ErrClosed.String()))
-		}
+	for _, v := range this.responseTables.blocks(func(block *responseTableBlock) bool {
+		return block.channel == channel
+	}) {
+		v.future.Exception(closedError("the channel is closed"))
 	}
 }
 
@@ -173,12 +347,12 @@ func (this *tenuredService) Start() error {
 
 func (this *tenuredService) waitRequest(interrupt bool) {
 	if interrupt {
-		for _, v := range this.responseTables {
-			v.future.Exception(errors.New(remoting.ErrClosed.String()))
+		for _, v := range this.responseTables.blocks(nil) {
+			v.future.Exception(closedError("the channel is closed"))
 		}
 	} else {
 		for {
-			if len(this.responseTables) == 0 {
+			if this.responseTables.size() == 0 {
 				return
 			}
 			<-time.After(time.Millisecond * 10)
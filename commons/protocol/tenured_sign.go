@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//认证签名：hex(HMAC-SHA256(secret, id + "\n" + timestamp + "\n" + nonce))，id为模块名称或者应用ID
+func HmacSign(secret, id string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//校验认证签名，签名时间超过误差的拒绝，误差时间内同一个id的nonce只能使用一次
+type SignVerifier struct {
+	//允许的签名时间误差，默认5分钟
+	MaxSkew time.Duration
+
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+func (this *SignVerifier) maxSkew() time.Duration {
+	if this.MaxSkew <= 0 {
+		return time.Minute * 5
+	}
+	return this.MaxSkew
+}
+
+//now为校验时的时间，校验通过后记录nonce
+func (this *SignVerifier) Verify(secret, id string, timestamp int64, nonce, sign string, now time.Time) error {
+	maxSkew := this.maxSkew()
+	signTime := time.Unix(timestamp, 0)
+	if skew := now.Sub(signTime); skew > maxSkew || skew < -maxSkew {
+		return errors.New(fmt.Sprintf("%s sign time skew %s", id, skew))
+	}
+	expect := HmacSign(secret, id, timestamp, nonce)
+	if nonce == "" || !hmac.Equal([]byte(expect), []byte(sign)) {
+		return errors.New(fmt.Sprintf("%s invalid sign", id))
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.nonces == nil {
+		this.nonces = map[string]time.Time{}
+	}
+	this.sweep(now, maxSkew)
+	key := id + "\n" + nonce
+	if _, has := this.nonces[key]; has {
+		return errors.New(fmt.Sprintf("%s nonce replayed", id))
+	}
+	//超过时间误差的签名会被拒绝，nonce只需要保留到此时
+	this.nonces[key] = signTime.Add(maxSkew)
+	return nil
+}
+
+//每个误差时间内最多清理一次过期的nonce
+func (this *SignVerifier) sweep(now time.Time, maxSkew time.Duration) {
+	if now.Sub(this.lastSweep) < maxSkew {
+		return
+	}
+	this.lastSweep = now
+	for key, expire := range this.nonces {
+		if now.After(expire) {
+			delete(this.nonces, key)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"fmt"
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startStressServer(t *testing.T, address string) *TenuredServer {
+	server, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	server.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	server.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = command.Body
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, server.Start())
+	return server
+}
+
+//服务端不断重启，客户端并发调用，go test -race 运行检查数据竞争，
+//每个调用都要在超时时间内返回，并且收到的响应必须是自己的请求的响应
+func TestTenured_StressReconnect(t *testing.T) {
+	address := "pipe://tenured-stress"
+	server := startStressServer(t, address)
+
+	config := remoting.DefaultConfig()
+	config.PoolSize = 2
+	config.ReconnectInterval = 10
+	config.ReconnectMaxInterval = 50
+	config.ReconnectMaxTimes = 0
+	client, err := NewTenuredClient(config)
+	assert.Nil(t, err)
+	client.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	stop := make(chan struct{})
+	restarted := make(chan struct{})
+	go func() {
+		defer close(restarted)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond * 50):
+			}
+			server.Shutdown(true)
+			time.Sleep(time.Millisecond * 10)
+			server = startStressServer(t, address)
+		}
+	}()
+
+	const workers, invokes = 50, 40
+	timeout := time.Millisecond * 500
+	var success, failed int32
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < invokes; i++ {
+				body := fmt.Sprintf("%d-%d", w, i)
+				request := NewRequest(HELLO)
+				request.Body = []byte(body)
+
+				done := make(chan struct{})
+				check := func(response *TenuredCommand, err error) {
+					defer close(done)
+					if err != nil {
+						atomic.AddInt32(&failed, 1)
+					} else {
+						atomic.AddInt32(&success, 1)
+						assert.Equal(t, body, string(response.Body))
+					}
+				}
+				if i%2 == 0 {
+					check(client.Invoke(address, request, timeout))
+				} else {
+					client.AsyncInvoke(address, request, timeout, check)
+				}
+				select {
+				case <-done:
+				case <-time.After(timeout + time.Second):
+					t.Error("invoke not returned: ", body)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(stop)
+	<-restarted
+	defer server.Shutdown(true)
+
+	t.Logf("success: %d, failed: %d", success, failed)
+	assert.True(t, atomic.LoadInt32(&success) > 0)
+	assert.Equal(t, int32(workers*invokes), atomic.LoadInt32(&success)+atomic.LoadInt32(&failed))
+
+	//服务端稳定后恢复调用
+	for i := 0; ; i++ {
+		if _, err := client.Invoke(address, NewRequest(HELLO), timeout); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal("not recovered: ", err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	assert.Equal(t, 0, client.responseTables.size())
+}
+
+type testChannel struct {
+	remoting.RemotingChannel
+	addr string
+}
+
+func (this *testChannel) RemoteAddr() string {
+	return this.addr
+}
+
+func TestResponseTable(t *testing.T) {
+	table := newResponseTable()
+	channelA, channelB := &testChannel{addr: "a"}, &testChannel{addr: "b"}
+
+	blockA, err := table.put(channelA, 1)
+	assert.Nil(t, err)
+	//不同的channel可以使用相同的请求ID
+	blockB, err := table.put(channelB, 1)
+	assert.Nil(t, err)
+	_, err = table.put(channelA, 1)
+	assert.NotNil(t, err)
+
+	block, has := table.get(channelB, 1)
+	assert.True(t, has)
+	assert.True(t, block == blockB)
+	assert.Equal(t, 1, len(table.blocks(func(block *responseTableBlock) bool {
+		return block.channel == channelA
+	})))
+
+	table.remove(1, blockA)
+	_, has = table.get(channelA, 1)
+	assert.False(t, has)
+	assert.Equal(t, 1, table.size())
+}
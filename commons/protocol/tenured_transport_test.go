@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testTransport(t *testing.T, address string) {
+	pipeServer, err := NewTenuredServer(address, nil)
+	assert.Nil(t, err)
+	pipeServer.AuthHeader = &AuthHeader{Module: "test", Address: address, Attributes: map[string]string{}}
+	pipeServer.RegisterCommandProcesser(HELLO, func(channel remoting.RemotingChannel, command *TenuredCommand) {
+		ack := NewACK(command.ID())
+		ack.Body = []byte(string(command.Body) + " tenured")
+		_ = channel.Write(ack, time.Second)
+	}, nil)
+	assert.Nil(t, pipeServer.Start())
+	defer pipeServer.Shutdown(true)
+
+	pipeClient, err := NewTenuredClient(nil)
+	assert.Nil(t, err)
+	pipeClient.AuthHeader = &AuthHeader{Module: "test", Attributes: map[string]string{}}
+	assert.Nil(t, pipeClient.Start())
+	defer pipeClient.Shutdown(true)
+
+	request := NewRequest(HELLO)
+	request.Body = []byte("hello")
+	response, err := pipeClient.Invoke(address, request, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, response.IsSuccess())
+	assert.Equal(t, "hello tenured", string(response.Body))
+}
+
+func TestTenured_PipeTransport(t *testing.T) {
+	testTransport(t, "pipe://tenured")
+}
+
+func TestTenured_WebSocketTransport(t *testing.T) {
+	testTransport(t, "ws://127.0.0.1:6084/tenured")
+}
@@ -0,0 +1,44 @@
+package registry
+
+//实例过滤器，返回false的实例不会被负载均衡选中，例如：服务端通知即将关闭(GOAWAY)的实例
+type InstanceFilter func(serverInstance *ServerInstance) bool
+
+//支持过滤实例的负载均衡
+type FilterLoadBalance interface {
+	LoadBalance
+
+	AddFilter(filter InstanceFilter)
+}
+
+//负载均衡支持过滤时添加过滤器
+func AddFilter(loadBalance LoadBalance, filter InstanceFilter) bool {
+	if flb, match := loadBalance.(FilterLoadBalance); match {
+		flb.AddFilter(filter)
+		return true
+	}
+	return false
+}
+
+type instanceFilters []InstanceFilter
+
+func (this instanceFilters) accept(serverInstance *ServerInstance) bool {
+	for _, filter := range this {
+		if !filter(serverInstance) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this instanceFilters) filter(serverInstances []*ServerInstance) []*ServerInstance {
+	if len(this) == 0 {
+		return serverInstances
+	}
+	out := make([]*ServerInstance, 0, len(serverInstances))
+	for _, serverInstance := range serverInstances {
+		if this.accept(serverInstance) {
+			out = append(out, serverInstance)
+		}
+	}
+	return out
+}
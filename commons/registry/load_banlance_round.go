@@ -6,13 +6,14 @@ type roundLoadBalance struct {
 	serverName string
 	rangeIndex *atomic.AtomicUInt32
 	reg        ServiceRegistry
+	filters    instanceFilters
 }
 
 func (this *roundLoadBalance) Select(obj ...interface{}) ([]*ServerInstance, string, error) {
 	currentRangeIndex := this.rangeIndex.GetAndIncrement()
 	if ss, err := this.reg.Lookup(this.serverName, nil); err != nil {
 		return nil, "", err
-	} else if len(ss) == 0 {
+	} else if ss = this.filters.filter(ss); len(ss) == 0 {
 		return ss, "", err
 	} else {
 		idx := int(currentRangeIndex % uint32(len(ss)))
@@ -24,6 +25,10 @@ func (this *roundLoadBalance) Return(key string) {
 
 }
 
+func (this *roundLoadBalance) AddFilter(filter InstanceFilter) {
+	this.filters = append(this.filters, filter)
+}
+
 func NewRoundLoadBalance(serverName string, reg ServiceRegistry) LoadBalance {
 	return &roundLoadBalance{
 		serverName: serverName, reg: reg,
@@ -1,11 +1,13 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
 	"github.com/emirpasic/gods/maps/treemap"
 	"github.com/emirpasic/gods/utils"
 	"github.com/ihaiker/tenured-go-server/commons/snowflake"
 	"hash/crc64"
+	"math"
 	"strconv"
 )
 
@@ -33,6 +35,8 @@ type TimedHashLoadBalance struct {
 
 	//保存注册服务
 	serverInstances map[string]*ServerInstance
+
+	filters instanceFilters
 }
 
 func (this *TimedHashLoadBalance) addSerInstance(instance *ServerInstance) {
@@ -81,24 +85,57 @@ func (this *TimedHashLoadBalance) Select(obj ...interface{}) ([]*ServerInstance,
 
 	hashCode := crc64.Checksum([]byte(fmt.Sprintf("%d", snowflakeId)), this.table)
 
-	_, value := this.tree.Find(func(key interface{}, value interface{}) bool {
+	key, value := this.tree.Find(func(key interface{}, value interface{}) bool {
 		return hashCode <= key.(uint64) && (value.(*element).StartTime <= petal.Time)
 	})
 	if value == nil {
-		_, value = this.tree.Find(func(key interface{}, value interface{}) bool {
+		key, value = this.tree.Find(func(key interface{}, value interface{}) bool {
 			return value.(*element).StartTime <= petal.Time
 		})
 	}
 	if value == nil {
-		_, value = this.tree.Min()
+		key, value = this.tree.Min()
 	}
 
 	serverId := value.(*element).Id
-	return []*ServerInstance{this.serverInstances[serverId]}, "", nil
+	//数据按照hash分区，不可用时不能选择其他实例
+	if !this.filters.accept(this.serverInstances[serverId]) {
+		return nil, "", errors.New("server instance unavailable: " + serverId)
+	}
+	serverInstances := []*ServerInstance{this.serverInstances[serverId]}
+	//第一个为数据所在的实例，写入只使用第一个，只读方法的对冲和重试使用后面的候选实例
+	if successor := this.successor(key.(uint64), serverId, petal.Time); successor != nil {
+		serverInstances = append(serverInstances, successor)
+	}
+	return serverInstances, "", nil
+}
+
+//hash环上顺时针方向下一个可用的其他实例，ID生成时还没有启动的实例不能作为候选
+func (this *TimedHashLoadBalance) successor(key uint64, serverId string, time uint64) *ServerInstance {
+	for i := 1; i < this.tree.Size(); i++ {
+		var next, value interface{}
+		if key < math.MaxUint64 {
+			next, value = this.tree.Ceiling(key + 1)
+		}
+		if next == nil {
+			next, value = this.tree.Min()
+		}
+		key = next.(uint64)
+		if element := value.(*element); element.Id != serverId && element.StartTime <= time {
+			if serverInstance := this.serverInstances[element.Id]; IsOK(serverInstance) && this.filters.accept(serverInstance) {
+				return serverInstance
+			}
+		}
+	}
+	return nil
 }
 
 func (this *TimedHashLoadBalance) Return(key string) {}
 
+func (this *TimedHashLoadBalance) AddFilter(filter InstanceFilter) {
+	this.filters = append(this.filters, filter)
+}
+
 func NewTimedHashLoadBalance(serverName string, registration ServiceRegistry, virtualNum int, snowflakeExport SnowflakeExport) LoadBalance {
 	hlb := &TimedHashLoadBalance{
 		serverName: serverName, registration: registration,
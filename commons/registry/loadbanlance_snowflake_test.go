@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+//Lookup返回固定实例的注册中心
+type hashTestRegistry struct {
+	serverInstances []*ServerInstance
+}
+
+func (this *hashTestRegistry) Register(serverInstance *ServerInstance) error {
+	return nil
+}
+
+func (this *hashTestRegistry) Unregister(serverId string) error {
+	return nil
+}
+
+func (this *hashTestRegistry) Subscribe(serverName string, listener RegistryNotifyListener) error {
+	return nil
+}
+
+func (this *hashTestRegistry) Unsubscribe(serverName string, listener RegistryNotifyListener) error {
+	return nil
+}
+
+func (this *hashTestRegistry) Lookup(serverName string, tags []string) ([]*ServerInstance, error) {
+	return this.serverInstances, nil
+}
+
+func hashTestInstance(id string) *ServerInstance {
+	return &ServerInstance{Id: id, Address: id, Status: "OK", Metadata: map[string]string{"FirstStartTime": "0"}}
+}
+
+func TestTimedHashLoadBalance_Successor(t *testing.T) {
+	export := func(requestCode uint16, parameters ...interface{}) uint64 {
+		return parameters[0].(uint64)
+	}
+	reg := &hashTestRegistry{serverInstances: []*ServerInstance{hashTestInstance("a")}}
+	single := NewTimedHashLoadBalance("store", reg, 10, export)
+	assert.Nil(t, single.(*TimedHashLoadBalance).Start())
+	selected, _, err := single.Select(uint16(1), uint64(1001))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(selected))
+
+	reg.serverInstances = []*ServerInstance{hashTestInstance("a"), hashTestInstance("b"), hashTestInstance("c")}
+	lb := NewTimedHashLoadBalance("store", reg, 10, export)
+	assert.Nil(t, lb.(*TimedHashLoadBalance).Start())
+	for id := uint64(1000); id < 1100; id++ {
+		selected, _, err := lb.Select(uint16(1), id)
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(selected)) {
+			assert.NotEqual(t, selected[0].Id, selected[1].Id)
+		}
+		//相同的ID总是选择相同的实例
+		again, _, _ := lb.Select(uint16(1), id)
+		assert.Equal(t, selected, again)
+	}
+
+	//不可用的实例不作为候选
+	selected, _, _ = lb.Select(uint16(1), uint64(1001))
+	owner, successor := selected[0].Id, selected[1].Id
+	AddFilter(lb, func(serverInstance *ServerInstance) bool {
+		return serverInstance.Id != successor
+	})
+	selected, _, err = lb.Select(uint16(1), uint64(1001))
+	assert.Nil(t, err)
+	assert.Equal(t, owner, selected[0].Id)
+	assert.NotEqual(t, successor, selected[1].Id)
+	assert.NotEqual(t, owner, selected[1].Id)
+}
@@ -0,0 +1,136 @@
+package remoting
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+//连接被拒绝的原因
+const (
+	REJECT_DENIED          = "denied"          //在DenyCIDR中
+	REJECT_NOT_ALLOWED     = "not_allowed"     //AllowCIDR不为空且不在其中
+	REJECT_MAX_CONNECTIONS = "max_connections" //超过最大连接数
+	REJECT_MAX_PER_IP      = "max_per_ip"      //超过单个IP最大连接数
+)
+
+//服务端连接准入控制，在accept时检查
+type admission struct {
+	maxConnections int
+	maxPerIP       int
+	allows         []*net.IPNet
+	denies         []*net.IPNet
+
+	lock     sync.Mutex
+	total    int
+	perIP    map[string]int
+	rejected map[string]uint64
+}
+
+//解析CIDR列表，单个IP按照/32(/128)处理
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip == nil {
+				return nil, errors.New("invalid cidr: " + cidr)
+			} else if ip.To4() != nil {
+				cidr = cidr + "/32"
+			} else {
+				cidr = cidr + "/128"
+			}
+		}
+		if _, ipNet, err := net.ParseCIDR(cidr); err != nil {
+			return nil, err
+		} else {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets, nil
+}
+
+func newAdmission(config *RemotingConfig) (*admission, error) {
+	allows, err := parseCIDRs(config.AllowCIDR)
+	if err != nil {
+		return nil, err
+	}
+	denies, err := parseCIDRs(config.DenyCIDR)
+	if err != nil {
+		return nil, err
+	}
+	return &admission{
+		maxConnections: config.MaxConnections, maxPerIP: config.MaxConnectionsPerIP,
+		allows: allows, denies: denies,
+		perIP: map[string]int{}, rejected: map[string]uint64{},
+	}, nil
+}
+
+//对端IP，pipe等非IP传输返回nil
+func ipOf(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//检查是否接入连接，接入返回空字符串，否则返回拒绝原因。接入的连接关闭时需要调用release
+func (this *admission) acquire(address string) string {
+	ip := ipOf(address)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	reason := ""
+	if ip != nil && contains(this.denies, ip) {
+		reason = REJECT_DENIED
+	} else if ip != nil && len(this.allows) > 0 && !contains(this.allows, ip) {
+		reason = REJECT_NOT_ALLOWED
+	} else if this.maxConnections > 0 && this.total >= this.maxConnections {
+		reason = REJECT_MAX_CONNECTIONS
+	} else if ip != nil && this.maxPerIP > 0 && this.perIP[ip.String()] >= this.maxPerIP {
+		reason = REJECT_MAX_PER_IP
+	}
+	if reason != "" {
+		this.rejected[reason]++
+		return reason
+	}
+
+	this.total++
+	if ip != nil {
+		this.perIP[ip.String()]++
+	}
+	return ""
+}
+
+func (this *admission) release(address string) {
+	ip := ipOf(address)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.total--
+	if ip != nil {
+		if this.perIP[ip.String()]--; this.perIP[ip.String()] <= 0 {
+			delete(this.perIP, ip.String())
+		}
+	}
+}
+
+func (this *admission) rejectedCount() map[string]uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	counts := make(map[string]uint64, len(this.rejected))
+	for reason, count := range this.rejected {
+		counts[reason] = count
+	}
+	return counts
+}
@@ -0,0 +1,84 @@
+package remoting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConnections = 3
+	config.MaxConnectionsPerIP = 2
+	config.AllowCIDR = []string{"10.0.0.0/8", "192.168.1.10"}
+	config.DenyCIDR = []string{"10.0.1.0/24"}
+	admission, err := newAdmission(config)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "", admission.acquire("10.0.0.1:1001"))
+	assert.Equal(t, "", admission.acquire("10.0.0.1:1002"))
+	assert.Equal(t, REJECT_MAX_PER_IP, admission.acquire("10.0.0.1:1003"))
+	assert.Equal(t, REJECT_DENIED, admission.acquire("10.0.1.1:1001"))
+	assert.Equal(t, REJECT_NOT_ALLOWED, admission.acquire("192.168.1.11:1001"))
+	assert.Equal(t, "", admission.acquire("192.168.1.10:1001"))
+	assert.Equal(t, REJECT_MAX_CONNECTIONS, admission.acquire("10.0.0.2:1001"))
+
+	admission.release("10.0.0.1:1001")
+	assert.Equal(t, "", admission.acquire("10.0.0.1:1003"))
+
+	rejected := admission.rejectedCount()
+	assert.Equal(t, uint64(1), rejected[REJECT_MAX_PER_IP])
+	assert.Equal(t, uint64(1), rejected[REJECT_DENIED])
+	assert.Equal(t, uint64(1), rejected[REJECT_NOT_ALLOWED])
+	assert.Equal(t, uint64(1), rejected[REJECT_MAX_CONNECTIONS])
+
+	config.DenyCIDR = []string{"10.0.0.300/8"}
+	_, err = newAdmission(config)
+	assert.NotNil(t, err)
+}
+
+func TestRemotingServer_MaxConnections(t *testing.T) {
+	address := "pipe://admission"
+	config := DefaultConfig()
+	config.MaxConnections = 1
+	server, _ := NewRemotingServer(address, config)
+	server.SetHandler(&HandlerWrapper{})
+	server.SetCoder(DefaultCoder())
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	transport, addr, _ := transportOf(address)
+	first, err := transport.Dial(addr, time.Second)
+	assert.Nil(t, err)
+	defer first.Close()
+
+	//超过最大连接数的连接被服务端直接关闭
+	second, err := transport.Dial(addr, time.Second)
+	assert.Nil(t, err)
+	_ = second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	for i := 0; i < 50 && server.Rejected()[REJECT_MAX_CONNECTIONS] == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, uint64(1), server.Rejected()[REJECT_MAX_CONNECTIONS])
+
+	//连接关闭后释放
+	_ = first.Close()
+	total := func() int {
+		server.admission.lock.Lock()
+		defer server.admission.lock.Unlock()
+		return server.admission.total
+	}
+	for i := 0; i < 50 && total() > 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	third, err := transport.Dial(addr, time.Second)
+	assert.Nil(t, err)
+	defer third.Close()
+	_ = third.SetReadDeadline(time.Now().Add(time.Millisecond * 300))
+	_, err = third.Read(make([]byte, 1))
+	if netErr, ok := err.(interface{ Timeout() bool }); assert.True(t, ok) {
+		assert.True(t, netErr.Timeout())
+	}
+}
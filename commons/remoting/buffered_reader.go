@@ -0,0 +1,132 @@
+package remoting
+
+import (
+	"io"
+	"sync"
+)
+
+//读取缓冲区默认大小
+const readBufferSize = 4 * 1024
+
+var readBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, readBufferSize)
+	},
+}
+
+//channel读取消息使用的带缓冲读取器。
+//一次Decode读取的数据在Decode成功之前都保留在缓冲区中，Decode过程中读超时时回退到消息开始位置，
+//下一次Decode从缓冲区重新读取，所以读超时和分多次到达的数据都不会导致数据流错位。
+type BufferedReader struct {
+	reader io.Reader
+	buf    []byte
+
+	mark int //当前消息开始位置
+	pos  int //读取位置
+	end  int //缓冲数据结束位置
+}
+
+func NewBufferedReader(reader io.Reader) *BufferedReader {
+	return &BufferedReader{reader: reader, buf: readBufferPool.Get().([]byte)}
+}
+
+//从底层读取数据到缓冲区，缓冲区满时先移除已经处理的消息，仍然不足时扩容
+func (this *BufferedReader) fill(need int) error {
+	if this.end+need > len(this.buf) {
+		if this.mark > 0 {
+			this.end = copy(this.buf, this.buf[this.mark:this.end])
+			this.pos -= this.mark
+			this.mark = 0
+		}
+		if this.end+need > len(this.buf) {
+			size := len(this.buf) * 2
+			for size < this.end+need {
+				size *= 2
+			}
+			buf := make([]byte, size)
+			copy(buf, this.buf[:this.end])
+			this.release()
+			this.buf = buf
+		}
+	}
+	n, err := this.reader.Read(this.buf[this.end:])
+	this.end += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+func (this *BufferedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if this.pos == this.end {
+		if err := this.fill(1); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, this.buf[this.pos:this.end])
+	this.pos += n
+	return n, nil
+}
+
+//读取n个字节，返回的数据引用内部缓冲区，只在下一次Decode之前有效，需要保留时调用方复制
+func (this *BufferedReader) Next(n int) ([]byte, error) {
+	for this.end-this.pos < n {
+		if err := this.fill(n - (this.end - this.pos)); err != nil {
+			if err == io.EOF && this.end > this.pos {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	bs := this.buf[this.pos : this.pos+n]
+	this.pos += n
+	return bs, nil
+}
+
+//已缓冲未读取的字节数
+func (this *BufferedReader) Buffered() int {
+	return this.end - this.pos
+}
+
+//开始读取一个消息
+func (this *BufferedReader) Begin() {
+	this.mark = this.pos
+}
+
+//消息读取完成，丢弃已经读取的数据
+func (this *BufferedReader) Commit() {
+	this.mark = this.pos
+	if this.pos == this.end {
+		this.pos, this.mark, this.end = 0, 0, 0
+		//大消息扩容的缓冲区不再保留
+		if len(this.buf) != readBufferSize {
+			this.release()
+			this.buf = readBufferPool.Get().([]byte)
+		}
+	}
+}
+
+//读取超时，回退到消息开始位置
+func (this *BufferedReader) Rollback() {
+	this.pos = this.mark
+}
+
+func (this *BufferedReader) release() {
+	if len(this.buf) == readBufferSize {
+		readBufferPool.Put(this.buf)
+	}
+	this.buf = nil
+}
+
+//channel关闭时归还缓冲区
+func (this *BufferedReader) Close() {
+	if this.buf != nil {
+		this.release()
+	}
+}
@@ -0,0 +1,86 @@
+package remoting
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+)
+
+//每次最多返回step个字节，并且每次读取数据之前先返回一次读超时
+type slowReader struct {
+	data    []byte
+	step    int
+	timeout bool
+}
+
+func (this *slowReader) Read(p []byte) (int, error) {
+	if len(this.data) == 0 {
+		return 0, io.EOF
+	}
+	if this.timeout = !this.timeout; this.timeout {
+		return 0, &net.OpError{Op: "read", Net: "pipe", Err: timeoutError{}}
+	}
+	n := this.step
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(this.data) {
+		n = len(this.data)
+	}
+	copy(p, this.data[:n])
+	this.data = this.data[n:]
+	return n, nil
+}
+
+func TestBufferedReader_Rollback(t *testing.T) {
+	data := []byte("0123456789abcdef")
+	reader := NewBufferedReader(&slowReader{data: data, step: 3})
+	defer reader.Close()
+
+	//每次读取4个字节，读超时时回退，数据流不错位
+	out := bytes.NewBuffer(nil)
+	timeouts := 0
+	for out.Len() < len(data) {
+		reader.Begin()
+		if bs, err := reader.Next(4); err != nil {
+			assert.True(t, isTimeout(err))
+			timeouts++
+			reader.Rollback()
+		} else {
+			out.Write(bs)
+			reader.Commit()
+		}
+	}
+	assert.Equal(t, string(data), out.String())
+	assert.True(t, timeouts > 0)
+
+	reader.Begin()
+	_, err := reader.Next(1)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBufferedReader_Grow(t *testing.T) {
+	data := bytes.Repeat([]byte("tenured"), readBufferSize)
+	reader := NewBufferedReader(bytes.NewReader(data))
+	defer reader.Close()
+
+	reader.Begin()
+	bs, err := reader.Next(len(data) - 1)
+	assert.Nil(t, err)
+	assert.Equal(t, data[:len(data)-1], bs)
+	reader.Commit()
+
+	one := make([]byte, 10)
+	n, err := reader.Read(one)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	reader.Commit()
+	//读取完成后扩容的缓冲区换回默认大小
+	assert.Equal(t, readBufferSize, len(reader.buf))
+
+	reader.Begin()
+	_, err = reader.Next(2)
+	assert.Equal(t, io.EOF, err)
+}
@@ -1,6 +1,7 @@
 package remoting
 
 import (
+	"crypto/tls"
 	"errors"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -33,7 +35,7 @@ type defChannel struct {
 	config *RemotingConfig
 
 	addr    string
-	conn    *net.TCPConn
+	conn    net.Conn
 	coder   RemotingCoder
 	handler RemotingHandler
 
@@ -48,7 +50,10 @@ type defChannel struct {
 
 	waitGroup   *sync.WaitGroup
 	idleTimer   *time.Timer
-	idleTimeout int
+	idleTimeout int32
+
+	batch       []sendMessage
+	writeBuffer []byte
 }
 
 func (this *defChannel) RemoteAddr() string {
@@ -58,6 +63,11 @@ func (this *defChannel) Attributes() map[string]interface{} {
 	return this.attributes
 }
 
+//等待发送的消息数量
+func (this *defChannel) Pending() int {
+	return len(this.sendChan)
+}
+
 func (this *defChannel) encodeMessage(msg interface{}) (bs []byte, err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -76,7 +86,8 @@ func (this *defChannel) encodeMessage(msg interface{}) (bs []byte, err error) {
 			err = &RemotingError{Op: ErrDecoder, Err: err}
 		}
 		return nil, err
-	} else if len(bs) > this.config.PacketBytesLimit {
+	} else if fragment, match := this.coder.(FragmentCoder); (!match || !fragment.Fragmented()) &&
+		len(bs) > this.config.PacketBytesLimit {
 		return nil, &RemotingError{Op: ErrPacketBytesLimit, Err: errors.New("the packet limit size " + strconv.Itoa(this.config.PacketBytesLimit))}
 	} else {
 		return bs, nil
@@ -124,8 +135,11 @@ func (this *defChannel) AsyncWrite(msg interface{}, timeout time.Duration, callb
 	_ = this.write(msg, timeout, callback)
 }
 
+//onClose为空时使用已经设置的onCloseFn
 func (this *defChannel) Do(onClose func(channel RemotingChannel)) error {
-	this.onCloseFn = onClose
+	if onClose != nil {
+		this.onCloseFn = onClose
+	}
 	go this.syncDo(this.readLoop)
 	if this.config.IdleTime > 0 {
 		go this.syncDo(this.heartbeatLoop)
@@ -143,6 +157,9 @@ func (this *defChannel) Close() {
 		logger.Debugf("channel close: %s", this.RemoteAddr())
 		this.idleTimer.Stop()
 		this.handler.OnClose(this)
+		if closer, match := this.coder.(RemotingCoderCloser); match {
+			closer.OnClose(this)
+		}
 		if this.onCloseFn != nil {
 			this.onCloseFn(this)
 		}
@@ -175,15 +192,97 @@ func (this *defChannel) writeLoop() {
 		case <-this.closeChan:
 			return
 		case msg := <-this.sendChan:
-			if msg.timeout.Before(time.Now()) {
-				msg.result <- &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout")}
-			} else if _, err := this.conn.Write(msg.msg); err != nil {
-				msg.result <- err
+			if this.config.WriteBatchBytes <= 0 {
+				this.writeMessage(msg)
+			} else {
+				this.writeBatch(msg)
+			}
+		}
+	}
+}
+
+func (this *defChannel) writeMessage(msg sendMessage) {
+	if msg.timeout.Before(time.Now()) {
+		msg.result <- &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout")}
+	} else if _, err := this.conn.Write(msg.msg); err != nil {
+		msg.result <- err
+	} else {
+		msg.result <- nil
+	}
+}
+
+//取出队列中的消息合并写出，直到达到WriteBatchBytes或者等待超过WriteLinger
+func (this *defChannel) writeBatch(first sendMessage) {
+	batch, size := this.batch[:0], 0
+	var linger <-chan time.Time
+	if this.config.WriteLinger > 0 {
+		timer := time.NewTimer(time.Duration(this.config.WriteLinger) * time.Microsecond)
+		defer timer.Stop()
+		linger = timer.C
+	}
+
+	now := time.Now()
+	msg, ok := first, true
+	for ok {
+		if msg.timeout.Before(now) {
+			msg.result <- &RemotingError{Op: ErrSendTimeout, Err: errors.New("send timeout")}
+		} else {
+			batch = append(batch, msg)
+			size += len(msg.msg)
+		}
+		if size >= this.config.WriteBatchBytes {
+			break
+		}
+		select {
+		case msg = <-this.sendChan:
+		default:
+			if linger == nil {
+				ok = false
 			} else {
-				msg.result <- nil
+				select {
+				case msg = <-this.sendChan:
+				case <-linger:
+					ok = false
+				case <-this.closeChan:
+					ok = false
+				}
 			}
 		}
 	}
+
+	var err error
+	if len(batch) == 1 {
+		_, err = this.conn.Write(batch[0].msg)
+	} else if len(batch) > 1 {
+		err = this.writeBuffers(batch, size)
+	}
+	for i := range batch {
+		batch[i].result <- err
+		batch[i] = sendMessage{}
+	}
+	this.batch = batch[:0]
+}
+
+//tcp和unix连接使用writev，其他连接(例如TLS)合并到一个缓冲区后写出
+func (this *defChannel) writeBuffers(batch []sendMessage, size int) (err error) {
+	switch this.conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		buffers := make(net.Buffers, len(batch))
+		for i, msg := range batch {
+			buffers[i] = msg.msg
+		}
+		_, err = buffers.WriteTo(this.conn)
+	default:
+		if cap(this.writeBuffer) < size {
+			this.writeBuffer = make([]byte, 0, size)
+		}
+		buffer := this.writeBuffer[:0]
+		for _, msg := range batch {
+			buffer = append(buffer, msg.msg...)
+		}
+		_, err = this.conn.Write(buffer)
+	}
+	return
 }
 
 func (this *defChannel) readLoop() {
@@ -192,13 +291,21 @@ func (this *defChannel) readLoop() {
 		this.Close()
 	}()
 	logger.Debug("channel start read loop:", this.RemoteAddr())
+	if tlsConn, match := this.conn.(*tls.Conn); match {
+		if err := tlsHandshake(tlsConn, time.Second*time.Duration(this.config.AcceptTimeout), this.attributes); err != nil {
+			logger.Infof("channel %s tls handshake error: %s", this.RemoteAddr(), err)
+			return
+		}
+	}
+	reader := NewBufferedReader(this.conn)
+	defer reader.Close()
 	for {
 		select {
 		case <-this.closeChan:
 			return
 		default:
-			if msg, err := this.decoderMessage(this.conn); err != nil {
-				if err != io.EOF && err != io.ErrUnexpectedEOF && err.Error() == "use of closed network connection" {
+			if msg, err := this.decoderMessage(reader); err != nil {
+				if err != io.EOF && err != io.ErrUnexpectedEOF && !strings.Contains(err.Error(), "use of closed") {
 					logger.Errorf("channel %s decoder error: %s ", this.RemoteAddr(), err)
 				}
 				return
@@ -224,7 +331,8 @@ func (this *defChannel) handlerMessage(msg interface{}) {
 	this.handler.OnMessage(this, msg)
 }
 
-func (this *defChannel) decoderMessage(conn *net.TCPConn) (msg interface{}, err error) {
+//读取一个消息，读超时返回nil，已经读取的部分消息保留在reader中下次继续读取
+func (this *defChannel) decoderMessage(reader *BufferedReader) (msg interface{}, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			err = commons.Catch(e)
@@ -235,15 +343,25 @@ func (this *defChannel) decoderMessage(conn *net.TCPConn) (msg interface{}, err
 	}()
 
 	_ = this.conn.SetReadDeadline(time.Now().Add(time.Second))
-	msg, err = this.coder.Decode(this, conn)
-	if err != nil {
-		if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-			err = nil
-		}
+	reader.Begin()
+	msg, err = this.coder.Decode(this, reader)
+	if err != nil && isTimeout(err) {
+		reader.Rollback()
+		return nil, nil
 	}
+	reader.Commit()
 	return
 }
 
+//编码器可能把读超时包装在RemotingError中
+func isTimeout(err error) bool {
+	if remotingErr, match := err.(*RemotingError); match {
+		err = remotingErr.Err
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
 func (c *defChannel) isClosed(err error) bool {
 	if strings.Contains(err.Error(), "connection reset by peer") {
 		return true
@@ -258,7 +376,7 @@ func (this *defChannel) heartbeatTimeout() time.Duration {
 }
 func (this *defChannel) resetReadIdle() {
 	this.idleTimer.Reset(this.heartbeatTimeout())
-	this.idleTimeout = 0
+	atomic.StoreInt32(&this.idleTimeout, 0)
 }
 
 func (this *defChannel) heartbeatLoop() {
@@ -273,9 +391,8 @@ func (this *defChannel) heartbeatLoop() {
 			return
 		case t := <-this.idleTimer.C:
 			timestr := t.Format("2006-01-02 15:04:05")
-			if this.idleTimeout+1 <= this.config.IdleTimeout {
+			if int(atomic.AddInt32(&this.idleTimeout, 1)) <= this.config.IdleTimeout {
 				logger.Debugf("SendIdle to: %s, time: %s", this.RemoteAddr(), timestr)
-				this.idleTimeout = this.idleTimeout + 1
 				this.idleTimer.Reset(idleCheckTime)
 				this.handler.OnIdle(this)
 			} else {
@@ -292,10 +409,16 @@ func (this *defChannel) syncDo(fn func()) {
 	fn()
 }
 
-func NewChannel(conn *net.TCPConn, config *RemotingConfig) *defChannel {
-	_ = conn.SetNoDelay(true)
-	_ = conn.SetKeepAlive(true)
-	_ = conn.SetKeepAlivePeriod(time.Duration(config.IdleTime) * time.Second) //这个地方依赖系统
+func setTCPOptions(conn net.Conn, config *RemotingConfig) {
+	if tcpConn, match := conn.(*net.TCPConn); match {
+		_ = tcpConn.SetNoDelay(true)
+		_ = tcpConn.SetKeepAlive(true)
+		_ = tcpConn.SetKeepAlivePeriod(time.Duration(config.IdleTime) * time.Second) //这个地方依赖系统
+	}
+}
+
+func NewChannel(conn net.Conn, config *RemotingConfig) *defChannel {
+	setTCPOptions(conn, config)
 
 	channel := &defChannel{
 		config:     config,
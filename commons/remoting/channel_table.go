@@ -0,0 +1,86 @@
+package remoting
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const channelTableShards = 32
+
+type channelTableShard struct {
+	lock     sync.RWMutex
+	channels map[string]RemotingChannel
+}
+
+//按key分片加锁的channel表，读协程、写协程和关闭回调并发访问
+type channelTable struct {
+	shards [channelTableShards]*channelTableShard
+}
+
+func newChannelTable() *channelTable {
+	table := &channelTable{}
+	for i := range table.shards {
+		table.shards[i] = &channelTableShard{channels: map[string]RemotingChannel{}}
+	}
+	return table
+}
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % channelTableShards)
+}
+
+func (this *channelTable) shard(key string) *channelTableShard {
+	return this.shards[shardIndex(key)]
+}
+
+func (this *channelTable) Get(key string) (RemotingChannel, bool) {
+	shard := this.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	channel, has := shard.channels[key]
+	return channel, has
+}
+
+func (this *channelTable) Put(key string, channel RemotingChannel) {
+	shard := this.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	shard.channels[key] = channel
+}
+
+//删除key对应的channel，key已经被新的channel替换时不删除
+func (this *channelTable) Remove(key string, channel RemotingChannel) bool {
+	shard := this.shard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if current, has := shard.channels[key]; has && current == channel {
+		delete(shard.channels, key)
+		return true
+	}
+	return false
+}
+
+func (this *channelTable) Len() int {
+	size := 0
+	for _, shard := range this.shards {
+		shard.lock.RLock()
+		size += len(shard.channels)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+//所有channel的快照
+func (this *channelTable) Values() []RemotingChannel {
+	channels := make([]RemotingChannel, 0)
+	for _, shard := range this.shards {
+		shard.lock.RLock()
+		for _, channel := range shard.channels {
+			channels = append(channels, channel)
+		}
+		shard.lock.RUnlock()
+	}
+	return channels
+}
@@ -0,0 +1,104 @@
+package remoting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//建立tcp连接，并启动写协程，对端读取的数据交给reader
+func newWriteChannel(t testing.TB, config *RemotingConfig, reader func(conn net.Conn)) *defChannel {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		if conn, err := listener.Accept(); err == nil {
+			reader(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := NewChannel(conn, config)
+	channel.coder = DefaultCoder()
+	channel.handler = &HandlerWrapper{}
+	channel.waitGroup = &sync.WaitGroup{}
+	go channel.writeLoop()
+	return channel
+}
+
+func TestChannel_WriteBatch(t *testing.T) {
+	config := DefaultConfig()
+	config.WriteBatchBytes = 1024
+	config.WriteLinger = 200
+
+	received := int64(0)
+	done := make(chan struct{})
+	channel := newWriteChannel(t, config, func(conn net.Conn) {
+		defer close(done)
+		n, _ := io.Copy(ioutil.Discard, conn)
+		atomic.StoreInt64(&received, n)
+	})
+
+	msg := make([]byte, 100)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.Nil(t, channel.Write(msg, time.Second*3))
+			}
+		}()
+	}
+	wg.Wait()
+	channel.Close()
+	<-done
+	assert.Equal(t, int64(50*20*100), atomic.LoadInt64(&received))
+
+	//超时的消息单独返回超时错误
+	result := make(chan error, 1)
+	writeChannel := newWriteChannel(t, config, func(conn net.Conn) { _, _ = io.Copy(ioutil.Discard, conn) })
+	defer writeChannel.Close()
+	writeChannel.writeBatch(sendMessage{msg: msg, timeout: time.Now().Add(-time.Second), result: result})
+	assert.True(t, IsRemotingError(<-result, ErrSendTimeout))
+}
+
+//多个协程并发发送小消息，对比合并写出前后的吞吐量：go test -run none -bench Channel_Write -cpu 4
+func benchmarkWriteLoop(b *testing.B, batchBytes, linger int) {
+	config := DefaultConfig()
+	config.WriteBatchBytes = batchBytes
+	config.WriteLinger = linger
+	channel := newWriteChannel(b, config, func(conn net.Conn) { _, _ = io.Copy(ioutil.Discard, conn) })
+	defer channel.Close()
+
+	msg := make([]byte, 64)
+	b.SetBytes(int64(len(msg)))
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := channel.Write(msg, time.Second*3); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+//每个消息单独写出
+func BenchmarkChannel_WriteSingle(b *testing.B) {
+	benchmarkWriteLoop(b, 0, 0)
+}
+
+//合并写出队列中已有的消息
+func BenchmarkChannel_WriteBatch(b *testing.B) {
+	benchmarkWriteLoop(b, 64*1024, 0)
+}
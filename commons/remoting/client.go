@@ -1,6 +1,9 @@
 package remoting
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"net"
 	"sync"
@@ -8,58 +11,148 @@ import (
 )
 
 type RemotingClient struct {
-	lock sync.Locker
+	dialLocks [channelTableShards]sync.Mutex //同一个key的连接串行建立，不同key之间不阻塞
+	tlsConfig *tls.Config
 	remotingImpl
+
+	stateLock   sync.Mutex
+	states      map[string]ChannelState
+	listeners   map[string]map[uint64]StateListener
+	listenerSeq uint64
+
+	reconnecting   map[string]bool //正在后台重连的地址
+	reconnectAgain map[string]bool //重连过程中又有channel断开的地址
 }
 
-func (this *RemotingClient) Start() error {
+func (this *RemotingClient) Start() (err error) {
+	if this.config.TLS != nil {
+		if this.tlsConfig, err = this.config.TLS.ClientConfig(); err != nil {
+			return err
+		}
+	}
 	if err := this.remotingImpl.Start(); err != nil {
-		return nil
+		return err
 	}
 	this.channelSelector = this.getChannel
+	this.remotingImpl.channelClosed = this.channelClosed
 	return nil
 }
 
+func (this *RemotingClient) poolSize() int {
+	if this.config.PoolSize < 1 {
+		return 1
+	}
+	return this.config.PoolSize
+}
+
+func poolKey(address string, slot int) string {
+	return fmt.Sprintf("%s#%d", address, slot)
+}
+
+//地址连接池中存活的channel和缺少channel的位置
+func (this *RemotingClient) pooledChannels(address string) (channels []RemotingChannel, missing []int) {
+	for slot := 0; slot < this.poolSize(); slot++ {
+		if channel, has := this.channels.Get(poolKey(address, slot)); has {
+			channels = append(channels, channel)
+		} else {
+			missing = append(missing, slot)
+		}
+	}
+	return
+}
+
+//选择待发送消息最少的channel
+func leastPending(channels []RemotingChannel) RemotingChannel {
+	selected, pending := channels[0], -1
+	for _, channel := range channels {
+		if counter, match := channel.(interface{ Pending() int }); !match {
+			return channel
+		} else if size := counter.Pending(); pending == -1 || size < pending {
+			selected, pending = channel, size
+		}
+	}
+	return selected
+}
+
 func (this *RemotingClient) getChannel(address string, timeout time.Duration) (RemotingChannel, error) {
-	if channel, err := this.remotingImpl.getChannel(address, timeout); err == nil {
-		return channel, nil
-	} else if noChannel, ok := err.(*RemotingError); ok && noChannel.Op == ErrNoChannel {
-		return this.createNewChannel(address, timeout)
+	if channels, _ := this.pooledChannels(address); len(channels) > 0 {
+		return leastPending(channels), nil
+	}
+	if this.isUnavailable(address) { //后台重连中，快速失败
+		return nil, &RemotingError{Op: ErrUnavailable, Err: errors.New("the address is reconnecting: " + address)}
+	}
+	return this.connect(address, timeout)
+}
+
+//同步建立第一个连接，连接池中其他连接在后台建立
+func (this *RemotingClient) connect(address string, timeout time.Duration) (RemotingChannel, error) {
+	this.fireState(address, STATE_CONNECTING)
+	if channel, err := this.createNewChannel(address, 0, timeout); err != nil {
+		this.forgetState(address, STATE_DISCONNECTED)
+		return channel, err
 	} else {
-		return nil, noChannel
+		this.changeState(address, STATE_CONNECTED)
+		if this.poolSize() > 1 {
+			this.startReconnect(address, true)
+		}
+		return channel, nil
 	}
 }
 
-func (this *RemotingClient) createNewChannel(address string, timeout time.Duration) (RemotingChannel, error) {
-	this.lock.Lock()
-	defer this.lock.Unlock()
+func (this *RemotingClient) createNewChannel(address string, slot int, timeout time.Duration) (RemotingChannel, error) {
+	key := poolKey(address, slot)
+	lock := &this.dialLocks[shardIndex(key)]
+	lock.Lock()
+	defer lock.Unlock()
 
-	if channel, ok := this.channels[address]; ok { //并发的双重检查
+	if channel, ok := this.channels.Get(key); ok { //并发的双重检查
 		return channel, nil
 	}
 
-	if conn, err := net.DialTimeout("tcp", address, timeout); err != nil {
+	transport, dialAddress, err := transportOf(address)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := transport.Dial(dialAddress, timeout); err != nil {
+		return nil, err
+	} else if conn, err = this.wrapConn(dialAddress, conn, timeout); err != nil {
 		return nil, err
 	} else {
-		channel, err := this.newChannel(address, conn.(*net.TCPConn))
+		channel, err := this.newChannel(key, address, conn)
 		return channel, err
 	}
 }
 
+func (this *RemotingClient) wrapConn(address string, conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if this.tlsConfig == nil {
+		return conn, nil
+	}
+	setTCPOptions(conn, this.config)
+	tlsConn := tls.Client(conn, clientTLSConfig(this.tlsConfig, address))
+	if err := tlsHandshake(tlsConn, timeout, nil); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 func NewRemotingClient(config *RemotingConfig) *RemotingClient {
 	if config == nil {
 		config = DefaultConfig()
 	}
 	client := &RemotingClient{
-		lock: &sync.Mutex{},
 		remotingImpl: remotingImpl{
 			config:    config,
-			channels:  make(map[string]RemotingChannel),
+			channels:  newChannelTable(),
 			exitChan:  make(chan struct{}),
 			status:    commons.S_STATUS_INIT,
 			waitGroup: &sync.WaitGroup{},
 			hocks:     map[Hock]func(){},
 		},
+		states:         map[string]ChannelState{},
+		listeners:      map[string]map[uint64]StateListener{},
+		reconnecting:   map[string]bool{},
+		reconnectAgain: map[string]bool{},
 	}
 	return client
 }
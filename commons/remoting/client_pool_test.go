@@ -0,0 +1,73 @@
+package remoting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type pendingChannel struct {
+	defChannel
+	pending int
+}
+
+func (this *pendingChannel) Pending() int {
+	return this.pending
+}
+
+func TestLeastPending(t *testing.T) {
+	a, b, c := &pendingChannel{pending: 3}, &pendingChannel{pending: 1}, &pendingChannel{pending: 2}
+	assert.True(t, leastPending([]RemotingChannel{a, b, c}) == b)
+	assert.True(t, leastPending([]RemotingChannel{a}) == a)
+}
+
+func waitPoolSize(t *testing.T, remoting *remotingImpl, size int) {
+	for i := 0; i < 100; i++ {
+		if remoting.channels.Len() == size {
+			return
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	t.Fatal("wait pool size timeout: ", size, remoting.channels.Len())
+}
+
+func TestRemotingClient_Pool(t *testing.T) {
+	address := "pipe://pool"
+	server, _ := NewRemotingServer(address, nil)
+	handler := &echoHandler{received: make(chan string, 100)}
+	server.SetHandler(handler)
+	server.SetCoder(DefaultCoder())
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	config := DefaultConfig()
+	config.PoolSize = 3
+	config.ReconnectInterval = 50
+	poolClient := NewRemotingClient(config)
+	poolClient.SetHandler(&HandlerWrapper{})
+	poolClient.SetCoder(DefaultCoder())
+	assert.Nil(t, poolClient.Start())
+	defer poolClient.Shutdown(true)
+
+	assert.Nil(t, poolClient.SendTo(address, []byte("hello"), time.Second))
+	waitPoolSize(t, &poolClient.remotingImpl, 3)
+	waitPoolSize(t, &server.remotingImpl, 3)
+
+	//单个channel断开不影响发送，并在后台补齐
+	channel, _ := poolClient.channels.Get(poolKey(address, 1))
+	channel.Close()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, poolClient.SendTo(address, []byte("hello"), time.Second))
+	}
+	state, _ := poolClient.State(address)
+	assert.Equal(t, STATE_CONNECTED, state)
+	waitPoolSize(t, &poolClient.remotingImpl, 3)
+
+	for i := 0; i < 11; i++ {
+		select {
+		case <-handler.received:
+		case <-time.After(time.Second * 3):
+			t.Fatal("not received")
+		}
+	}
+}
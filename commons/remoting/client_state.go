@@ -0,0 +1,225 @@
+package remoting
+
+import (
+	"math/rand"
+	"time"
+)
+
+//客户端到某个地址的连接状态
+type ChannelState int
+
+const (
+	STATE_CONNECTING   ChannelState = iota //正在连接
+	STATE_CONNECTED                        //已连接
+	STATE_DISCONNECTED                     //连接断开，等待重连
+	STATE_GIVEN_UP                         //多次重连失败，放弃重连
+)
+
+func (this ChannelState) String() string {
+	switch this {
+	case STATE_CONNECTING:
+		return "connecting"
+	case STATE_CONNECTED:
+		return "connected"
+	case STATE_DISCONNECTED:
+		return "disconnected"
+	case STATE_GIVEN_UP:
+		return "given-up"
+	}
+	return "unknown"
+}
+
+//连接状态变化监听
+type StateListener func(address string, state ChannelState)
+
+//订阅地址的连接状态变化，address为空时订阅所有地址。返回取消订阅的方法
+func (this *RemotingClient) Subscribe(address string, listener StateListener) func() {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+
+	this.listenerSeq++
+	id := this.listenerSeq
+	if _, has := this.listeners[address]; !has {
+		this.listeners[address] = map[uint64]StateListener{}
+	}
+	this.listeners[address][id] = listener
+
+	return func() {
+		this.stateLock.Lock()
+		defer this.stateLock.Unlock()
+		delete(this.listeners[address], id)
+		if len(this.listeners[address]) == 0 {
+			delete(this.listeners, address)
+		}
+	}
+}
+
+//获取地址当前连接状态，从未连接或者已经放弃重连的地址返回false
+func (this *RemotingClient) State(address string) (ChannelState, bool) {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	state, has := this.states[address]
+	return state, has
+}
+
+//地址正在后台重连，调用直接失败
+func (this *RemotingClient) isUnavailable(address string) bool {
+	state, has := this.State(address)
+	return has && state != STATE_CONNECTED
+}
+
+func (this *RemotingClient) fireState(address string, state ChannelState) {
+	this.stateLock.Lock()
+	listeners := make([]StateListener, 0)
+	for _, key := range []string{address, ""} {
+		for _, listener := range this.listeners[key] {
+			listeners = append(listeners, listener)
+		}
+	}
+	this.stateLock.Unlock()
+
+	for _, listener := range listeners {
+		listener(address, state)
+	}
+}
+
+//修改地址状态并通知监听
+func (this *RemotingClient) changeState(address string, state ChannelState) {
+	this.stateLock.Lock()
+	this.states[address] = state
+	this.stateLock.Unlock()
+	this.fireState(address, state)
+}
+
+//删除地址状态并通知监听
+func (this *RemotingClient) forgetState(address string, state ChannelState) {
+	this.stateLock.Lock()
+	delete(this.states, address)
+	this.stateLock.Unlock()
+	this.fireState(address, state)
+}
+
+func (this *RemotingClient) channelClosed(address string) {
+	if state, has := this.State(address); !has || state != STATE_CONNECTED {
+		//连接或者重连过程中(例如认证失败)关闭的channel，由连接方处理
+		return
+	}
+	channels, _ := this.pooledChannels(address)
+	if !this.IsActive() || this.config.ReconnectInterval <= 0 {
+		if len(channels) == 0 {
+			this.forgetState(address, STATE_DISCONNECTED)
+		}
+		return
+	}
+	if len(channels) == 0 {
+		this.changeState(address, STATE_DISCONNECTED)
+	}
+	//连接池中还有可用的channel时，后台补齐断开的连接
+	this.startReconnect(address, false)
+}
+
+//启动地址的后台重连，每个地址只有一个重连协程
+func (this *RemotingClient) startReconnect(address string, immediately bool) {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	if this.reconnecting[address] {
+		this.reconnectAgain[address] = true
+		return
+	}
+	this.reconnecting[address] = true
+	this.waitGroup.Add(1)
+	go this.reconnect(address, immediately)
+}
+
+//重连协程退出，如果期间又有channel断开返回false继续重连，force时直接退出
+func (this *RemotingClient) reconnectDone(address string, force bool) bool {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	if !force && this.reconnectAgain[address] {
+		delete(this.reconnectAgain, address)
+		return false
+	}
+	delete(this.reconnectAgain, address)
+	delete(this.reconnecting, address)
+	return true
+}
+
+//第attempts次重连等待时间，指数增长，并在[interval/2,interval]之间随机抖动，防止所有客户端同时重连
+func (this *RemotingClient) backoff(attempts int) time.Duration {
+	interval := time.Duration(this.config.ReconnectInterval) * time.Millisecond
+	maxInterval := time.Duration(this.config.ReconnectMaxInterval) * time.Millisecond
+	for i := 0; i < attempts && (maxInterval <= 0 || interval < maxInterval); i++ {
+		interval = interval * 2
+	}
+	if maxInterval > 0 && interval > maxInterval {
+		interval = maxInterval
+	}
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+//补齐地址连接池中断开的连接，连接池全部断开时发送状态事件
+func (this *RemotingClient) reconnect(address string, immediately bool) {
+	defer this.waitGroup.Done()
+	timeout := time.Duration(this.config.AcceptTimeout) * time.Second
+
+	for attempts := 0; ; {
+		if !immediately || attempts > 0 {
+			select {
+			case <-this.exitChan:
+				this.reconnectDone(address, true)
+				return
+			case <-time.After(this.backoff(attempts)):
+			}
+		}
+		if !this.IsActive() {
+			this.reconnectDone(address, true)
+			return
+		}
+
+		channels, missing := this.pooledChannels(address)
+		if len(channels) == 0 {
+			this.changeState(address, STATE_CONNECTING)
+		}
+		var err error
+		for _, slot := range missing {
+			if _, err = this.createNewChannel(address, slot, timeout); err != nil {
+				logger.Debugf("reconnect %s (%d) error: %s", address, attempts+1, err)
+				break
+			}
+		}
+		if channels, missing = this.pooledChannels(address); len(channels) > 0 {
+			if state, _ := this.State(address); state != STATE_CONNECTED {
+				logger.Infof("reconnect %s success", address)
+				this.changeState(address, STATE_CONNECTED)
+			}
+		}
+
+		if err == nil && len(missing) == 0 {
+			if this.reconnectDone(address, false) {
+				return
+			}
+			attempts, immediately = 0, false
+			continue
+		}
+
+		attempts++
+		if this.config.ReconnectInterval <= 0 ||
+			(this.config.ReconnectMaxTimes > 0 && attempts >= this.config.ReconnectMaxTimes) {
+			if len(channels) == 0 {
+				logger.Warnf("reconnect %s failed %d times, give up", address, attempts)
+				this.forgetState(address, STATE_GIVEN_UP)
+				this.reconnectDone(address, true)
+				return
+			}
+			if this.reconnectDone(address, false) {
+				return
+			}
+			attempts, immediately = 0, false
+			continue
+		}
+		if len(channels) == 0 {
+			this.changeState(address, STATE_DISCONNECTED)
+		}
+	}
+}
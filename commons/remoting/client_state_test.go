@@ -0,0 +1,104 @@
+package remoting
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func startPipeServer(t *testing.T, address string) *RemotingServer {
+	server, _ := NewRemotingServer(address, nil)
+	server.SetHandler(&HandlerWrapper{})
+	server.SetCoder(DefaultCoder())
+	assert.Nil(t, server.Start())
+	return server
+}
+
+func waitState(t *testing.T, states chan ChannelState, expect ChannelState) {
+	for {
+		select {
+		case state := <-states:
+			if state == expect {
+				return
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("wait state timeout: ", expect)
+		}
+	}
+}
+
+func TestRemotingClient_Reconnect(t *testing.T) {
+	address := "pipe://reconnect"
+	server := startPipeServer(t, address)
+
+	config := DefaultConfig()
+	config.ReconnectInterval = 50
+	config.ReconnectMaxInterval = 200
+	reconnectClient := NewRemotingClient(config)
+	reconnectClient.SetHandler(&HandlerWrapper{})
+	reconnectClient.SetCoder(DefaultCoder())
+	assert.Nil(t, reconnectClient.Start())
+	defer reconnectClient.Shutdown(true)
+
+	states := make(chan ChannelState, 100)
+	unsubscribe := reconnectClient.Subscribe(address, func(addr string, state ChannelState) {
+		assert.Equal(t, address, addr)
+		states <- state
+	})
+	defer unsubscribe()
+
+	assert.Nil(t, reconnectClient.SendTo(address, []byte("hello"), time.Second))
+	waitState(t, states, STATE_CONNECTED)
+
+	server.Shutdown(true)
+	waitState(t, states, STATE_DISCONNECTED)
+
+	//后台重连期间快速失败
+	err := reconnectClient.SendTo(address, []byte("hello"), time.Second)
+	assert.True(t, IsRemotingError(err, ErrUnavailable))
+
+	server = startPipeServer(t, address)
+	defer server.Shutdown(true)
+	waitState(t, states, STATE_CONNECTED)
+	assert.Nil(t, reconnectClient.SendTo(address, []byte("hello"), time.Second))
+}
+
+func TestRemotingClient_ReconnectGiveUp(t *testing.T) {
+	address := "pipe://reconnect-give-up"
+	server := startPipeServer(t, address)
+
+	config := DefaultConfig()
+	config.ReconnectInterval = 20
+	config.ReconnectMaxInterval = 50
+	config.ReconnectMaxTimes = 3
+	reconnectClient := NewRemotingClient(config)
+	reconnectClient.SetHandler(&HandlerWrapper{})
+	reconnectClient.SetCoder(DefaultCoder())
+	assert.Nil(t, reconnectClient.Start())
+	defer reconnectClient.Shutdown(true)
+
+	states := make(chan ChannelState, 100)
+	reconnectClient.Subscribe("", func(addr string, state ChannelState) {
+		states <- state
+	})
+
+	assert.Nil(t, reconnectClient.SendTo(address, []byte("hello"), time.Second))
+	server.Shutdown(true)
+	waitState(t, states, STATE_GIVEN_UP)
+
+	_, known := reconnectClient.State(address)
+	assert.False(t, known)
+}
+
+func TestRemotingClient_Backoff(t *testing.T) {
+	config := DefaultConfig()
+	config.ReconnectInterval = 100
+	config.ReconnectMaxInterval = 1000
+	backoffClient := NewRemotingClient(config)
+
+	for attempts, expect := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		expect = expect * time.Millisecond
+		delay := backoffClient.backoff(attempts)
+		assert.True(t, delay >= expect/2 && delay <= expect, delay)
+	}
+}
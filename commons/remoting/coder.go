@@ -12,6 +12,16 @@ type RemotingCoder interface {
 
 type RemotingCoderFactory func(RemotingChannel, RemotingConfig) RemotingCoder
 
+//编码器需要在channel关闭时释放资源（例如未接收完整的分片）时实现此接口
+type RemotingCoderCloser interface {
+	OnClose(channel RemotingChannel)
+}
+
+//支持分片的编码器，Encode返回的数据由多个不超过PacketBytesLimit的帧组成，消息长度由编码器检查
+type FragmentCoder interface {
+	Fragmented() bool
+}
+
 type Bytes1024Coder struct{}
 
 func (this *Bytes1024Coder) Decode(channel RemotingChannel, reader io.Reader) (interface{}, error) {
@@ -11,6 +11,12 @@ type RemotingConfig struct {
 	// the limit of packet send channel
 	PacketBytesLimit int `json:"packetBytesLimit" yaml:"packetBytesLimit"`
 
+	//超过PacketBytesLimit的消息分片发送，分片组装后的最大长度，小于等于0时不分片
+	MaxMessageBytes int `json:"maxMessageBytes" yaml:"maxMessageBytes"`
+
+	//每个channel同时接收的未完整分片消息数量
+	MaxPartialMessages int `json:"maxPartialMessages" yaml:"maxPartialMessages"`
+
 	AcceptTimeout int `json:"acceptTimeout" yaml:"acceptTimeout"`
 
 	//heartbeat time,and timeout SECONDS
@@ -18,6 +24,51 @@ type RemotingConfig struct {
 
 	//连续几次heartbeat不传递就就认为掉线
 	IdleTimeout int `json:"idleTimeout" yaml:"idleTimeout"`
+
+	//支持的压缩算法，按优先级逗号分隔，例如：deflate,gzip。认证时和对端协商，为空时不压缩
+	Compress string `json:"compress,omitempty" yaml:"compress,omitempty"`
+
+	//支持的header编码，按优先级逗号分隔，例如：msgpack,json。认证时和对端协商，为空时使用json
+	HeaderCodec string `json:"headerCodec,omitempty" yaml:"headerCodec,omitempty"`
+
+	//消息头和消息体超过该字节数时压缩
+	CompressThreshold int `json:"compressThreshold" yaml:"compressThreshold"`
+
+	//合并发送的最大字节数，写协程一次取出多个待发送消息合并写出，小于等于0时每个消息单独写出
+	WriteBatchBytes int `json:"writeBatchBytes" yaml:"writeBatchBytes"`
+
+	//合并发送时等待更多消息的最长时间（微秒），0不等待只合并已经在队列中的消息
+	WriteLinger int `json:"writeLinger" yaml:"writeLinger"`
+
+	//客户端到每个地址的连接数量，发送时选择待发送消息最少的连接
+	PoolSize int `json:"poolSize" yaml:"poolSize"`
+
+	//服务端最大连接数，0不限制
+	MaxConnections int `json:"maxConnections" yaml:"maxConnections"`
+
+	//服务端单个IP最大连接数，0不限制
+	MaxConnectionsPerIP int `json:"maxConnectionsPerIP" yaml:"maxConnectionsPerIP"`
+
+	//允许连接的IP段，例如：10.0.0.0/8, 192.168.1.10。为空时允许所有
+	AllowCIDR []string `json:"allowCIDR,omitempty" yaml:"allowCIDR,omitempty"`
+
+	//拒绝连接的IP段，优先于AllowCIDR
+	DenyCIDR []string `json:"denyCIDR,omitempty" yaml:"denyCIDR,omitempty"`
+
+	//服务端正常关闭时等待正在处理的请求完成的最长时间(秒)
+	DrainTimeout int `json:"drainTimeout" yaml:"drainTimeout"`
+
+	//断线重连初始间隔（毫秒），之后每次失败间隔翻倍并加入随机抖动，小于等于0时不自动重连
+	ReconnectInterval int `json:"reconnectInterval" yaml:"reconnectInterval"`
+
+	//断线重连最大间隔（毫秒）
+	ReconnectMaxInterval int `json:"reconnectMaxInterval" yaml:"reconnectMaxInterval"`
+
+	//连续重连失败次数超过后放弃重连，0不限制
+	ReconnectMaxTimes int `json:"reconnectMaxTimes" yaml:"reconnectMaxTimes"`
+
+	//TLS加密传输，为空时不加密
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
 }
 
 func (cfg *RemotingConfig) String() string {
@@ -32,5 +83,19 @@ func DefaultConfig() *RemotingConfig {
 		AcceptTimeout:    3,
 		IdleTime:         15,
 		IdleTimeout:      3,
+		PoolSize:         1,
+		WriteBatchBytes:  64 * 1024,
+		WriteLinger:      0,
+
+		MaxMessageBytes:    4 * 1024 * 1024,
+		MaxPartialMessages: 16,
+
+		CompressThreshold: 512,
+		DrainTimeout:      10,
+
+		//默认不自动重连，设置ReconnectInterval后生效
+		ReconnectInterval:    0,
+		ReconnectMaxInterval: 30000,
+		ReconnectMaxTimes:    20,
 	}
 }
@@ -21,7 +21,8 @@ const (
 	ErrClosed           = ErrorType("Closed")
 	ErrSendTimeout      = ErrorType("Timeout")
 
-	ErrNoChannel = ErrorType("NoChannel")
+	ErrNoChannel   = ErrorType("NoChannel")
+	ErrUnavailable = ErrorType("Unavailable")
 )
 
 type RemotingError struct {
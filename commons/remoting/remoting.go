@@ -27,6 +27,9 @@ type Remoting interface {
 	SetHandler(handler RemotingHandler)
 	RegisterHock(hock Hock, fn func())
 
+	//获取地址对应的channel，客户端没有连接时创建连接
+	GetChannel(address string, timeout time.Duration) (RemotingChannel, error)
+
 	SendTo(address string, msg interface{}, timeout time.Duration) error
 	SyncSendTo(address string, msg interface{}, timeout time.Duration, callback func(error))
 
@@ -36,7 +39,7 @@ type Remoting interface {
 
 type remotingImpl struct {
 	config   *RemotingConfig
-	channels map[string]RemotingChannel
+	channels *channelTable
 
 	status   commons.ServerStatus
 	exitChan chan struct{} // notify all goroutines to shutdown
@@ -47,6 +50,7 @@ type remotingImpl struct {
 
 	hocks           map[Hock]func()
 	channelSelector func(address string, timeout time.Duration) (RemotingChannel, error)
+	channelClosed   func(address string)
 }
 
 func (this *remotingImpl) SetCoderFactory(coderFactory RemotingCoderFactory) {
@@ -95,6 +99,10 @@ func (this *remotingImpl) Start() error {
 	}
 }
 
+func (this *remotingImpl) GetChannel(address string, timeout time.Duration) (RemotingChannel, error) {
+	return this.channelSelector(address, timeout)
+}
+
 func (this *remotingImpl) SendTo(address string, msg interface{}, timeout time.Duration) error {
 	timeoutTime := time.Now().Add(timeout)
 	if channel, err := this.channelSelector(address, timeout); err != nil {
@@ -121,27 +129,33 @@ func (this *remotingImpl) SyncSendTo(address string, msg interface{}, timeout ti
 }
 
 func (this *remotingImpl) getChannel(address string, timeout time.Duration) (RemotingChannel, error) {
-	if channel, ok := this.channels[address]; ok {
+	if channel, ok := this.channels.Get(address); ok {
 		return channel, nil
 	} else {
 		return nil, &RemotingError{Op: ErrNoChannel, Err: errors.New("not found channel " + address)}
 	}
 }
 
-func (this *remotingImpl) newChannel(address string, conn *net.TCPConn) (RemotingChannel, error) {
+//新建channel，key为channels中保存的键，客户端连接池中同一个地址有多个channel
+func (this *remotingImpl) newChannel(key, address string, conn net.Conn) (RemotingChannel, error) {
 	this.waitGroup.Add(1)
-	logger.Debugf("new channel：%s", address)
+	logger.Debugf("new channel：%s", key)
 
 	channel := NewChannel(conn, this.config)
 	channel.addr = address
 	channel.waitGroup = this.waitGroup
 	channel.coder = this.coderFactory(channel, *this.config)
 	channel.handler = this.handlerFactory(channel, *this.config)
-	this.channels[address] = channel
-	err := channel.Do(func(ch RemotingChannel) {
-		delete(this.channels, ch.RemoteAddr())
+	//channel放入channels后可能立即被其他协程关闭，所以在放入之前设置关闭回调
+	channel.onCloseFn = func(ch RemotingChannel) {
+		this.channels.Remove(key, ch)
+		if this.channelClosed != nil {
+			this.channelClosed(ch.RemoteAddr())
+		}
 		this.waitGroup.Done()
-	})
+	}
+	this.channels.Put(key, channel)
+	err := channel.Do(nil)
 	return channel, err
 }
 
@@ -154,10 +168,8 @@ func (this *remotingImpl) IsStatus(status commons.ServerStatus) bool {
 }
 
 func (this *remotingImpl) closeChannels() {
-	for _, v := range this.channels {
-		if v != nil {
-			v.Close()
-		}
+	for _, v := range this.channels.Values() {
+		v.Close()
 	}
 }
 
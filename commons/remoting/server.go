@@ -1,37 +1,101 @@
 package remoting
 
 import (
+	"crypto/tls"
 	"github.com/ihaiker/tenured-go-server/commons"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type RemotingServer struct {
-	address string
+	address   string
+	tlsConfig *tls.Config
+	listener  Listener
+	draining  int32
+	admission *admission
 	remotingImpl
 }
 
-func (this *RemotingServer) Start() error {
-	if err := this.remotingImpl.Start(); err != nil {
-		return nil
+func (this *RemotingServer) Start() (err error) {
+	if this.config.TLS != nil {
+		if this.tlsConfig, err = this.config.TLS.ServerConfig(); err != nil {
+			return err
+		}
+	}
+	if this.admission, err = newAdmission(this.config); err != nil {
+		return err
+	}
+	this.channelClosed = this.admission.release
+	if err = this.remotingImpl.Start(); err != nil {
+		return err
 	}
 
-	if tcpAddr, err := net.ResolveTCPAddr("tcp4", this.address); err != nil {
+	if transport, address, err := transportOf(this.address); err != nil {
 		return err
-	} else if listener, err := net.ListenTCP("tcp", tcpAddr); err != nil {
+	} else if listener, err := transport.Listen(address); err != nil {
 		return err
 	} else {
+		this.listener = listener
 		go this.startListener(listener)
 		return nil
 	}
 }
-func (this *RemotingServer) startListener(listener *net.TCPListener) {
+
+//优雅关闭：停止接入新的连接，通过notify通知所有已连接的对端，
+//然后等待idle返回true(正在处理的请求完成)，最长等待DrainTimeout秒。调用后需要调用Shutdown关闭服务
+func (this *RemotingServer) Drain(notify func(channel RemotingChannel), idle func() bool) {
+	if !this.IsActive() || !atomic.CompareAndSwapInt32(&this.draining, 0, 1) {
+		return
+	}
+	logger.Infof("server drain: %s", this.address)
+	if this.listener != nil {
+		_ = this.listener.Close()
+	}
+	if notify != nil {
+		for _, channel := range this.channels.Values() {
+			notify(channel)
+		}
+	}
+	deadline := time.Now().Add(time.Duration(this.config.DrainTimeout) * time.Second)
+	for idle != nil && !idle() {
+		if time.Now().After(deadline) {
+			logger.Warnf("server drain timeout: %s", this.address)
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (this *RemotingServer) IsDraining() bool {
+	return atomic.LoadInt32(&this.draining) == 1
+}
+
+//被拒绝的连接数量，key为拒绝原因(REJECT_*)
+func (this *RemotingServer) Rejected() map[string]uint64 {
+	if this.admission == nil {
+		return map[string]uint64{}
+	}
+	return this.admission.rejectedCount()
+}
+
+func (this *RemotingServer) Shutdown(interrupt bool) {
+	//先关闭监听，不再接入新的连接
+	if this.listener != nil {
+		_ = this.listener.Close()
+	}
+	this.remotingImpl.Shutdown(interrupt)
+}
+
+func (this *RemotingServer) startListener(listener Listener) {
 	this.waitGroup.Add(1)
 	defer func() {
 		_ = listener.Close()
 		this.waitGroup.Done()
-		this.Shutdown(false)
+		if !this.IsDraining() {
+			this.Shutdown(false)
+		}
 	}()
 	logger.Infof("server startup：%s", listener.Addr().String())
 
@@ -42,22 +106,43 @@ func (this *RemotingServer) startListener(listener *net.TCPListener) {
 		case <-this.exitChan:
 			return
 		default:
-			conn, err := listener.AcceptTCP()
+			conn, err := listener.Accept()
 			if err != nil {
-				if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue
-				} else {
+				} else if this.IsActive() && !this.IsDraining() {
 					logger.Errorf("Service monitoring error：%s", err)
-					return
 				}
+				return
+			}
+			select {
+			case <-this.exitChan: //关闭过程中接入的连接直接拒绝
+				_ = conn.Close()
+				return
+			default:
 			}
 			address := conn.RemoteAddr().String()
-			if _, err = this.newChannel(address, conn); err != nil {
+			if reason := this.admission.acquire(address); reason != "" {
+				logger.Warnf("the server reject connection %s: %s", address, reason)
+				_ = conn.Close()
+				continue
+			}
+			if _, err = this.newChannel(address, address, this.wrapConn(conn)); err != nil {
 				logger.Infof("the server reject connection. %s", err.Error())
 			}
 		}
 	}
 }
+
+//启用TLS时，握手在channel的读协程中完成，不阻塞accept
+func (this *RemotingServer) wrapConn(conn net.Conn) net.Conn {
+	if this.tlsConfig == nil {
+		return conn
+	}
+	setTCPOptions(conn, this.config)
+	return tls.Server(conn, this.tlsConfig)
+}
+
 func NewRemotingServer(address string, config *RemotingConfig) (*RemotingServer, error) {
 	if config == nil {
 		config = DefaultConfig()
@@ -66,7 +151,7 @@ func NewRemotingServer(address string, config *RemotingConfig) (*RemotingServer,
 		address: address,
 		remotingImpl: remotingImpl{
 			config:    config,
-			channels:  make(map[string]RemotingChannel),
+			channels:  newChannelTable(),
 			exitChan:  make(chan struct{}),
 			status:    commons.S_STATUS_INIT,
 			waitGroup: &sync.WaitGroup{},
@@ -0,0 +1,132 @@
+package remoting
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+//通过TLS认证的对端证书信息，保存在 RemotingChannel.Attributes() 中
+const (
+	TLS_PEER_SUBJECT     = "tls_peer_subject"     //对端证书Subject，例如：CN=tenured_store,O=tenured
+	TLS_PEER_COMMON_NAME = "tls_peer_common_name" //对端证书CommonName
+	TLS_PEER_CERTIFICATE = "tls_peer_certificate" //对端证书 *x509.Certificate
+)
+
+type TLSConfig struct {
+	//证书文件(PEM)
+	CertFile string `json:"certFile" yaml:"certFile"`
+
+	//证书私钥文件(PEM)
+	KeyFile string `json:"keyFile" yaml:"keyFile"`
+
+	//CA证书文件(PEM)，服务端用于校验客户端证书，客户端用于校验服务端证书。为空时客户端使用系统证书
+	CAFile string `json:"caFile" yaml:"caFile"`
+
+	//服务端是否要求并校验客户端证书（mutual TLS）
+	ClientAuth bool `json:"clientAuth" yaml:"clientAuth"`
+
+	//客户端校验的服务端证书名称，为空时使用连接地址的主机名
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+
+	//跳过服务端证书校验，仅用于测试环境
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+func (this *TLSConfig) certPool() (*x509.CertPool, error) {
+	if this.CAFile == "" {
+		return nil, nil
+	}
+	bs, err := ioutil.ReadFile(this.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return nil, errors.New("invalid ca file: " + this.CAFile)
+	}
+	return pool, nil
+}
+
+func (this *TLSConfig) certificates() ([]tls.Certificate, error) {
+	if this.CertFile == "" && this.KeyFile == "" {
+		return nil, nil
+	}
+	if cert, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile); err != nil {
+		return nil, err
+	} else {
+		return []tls.Certificate{cert}, nil
+	}
+}
+
+func (this *TLSConfig) ServerConfig() (*tls.Config, error) {
+	certs, err := this.certificates()
+	if err != nil {
+		return nil, err
+	} else if len(certs) == 0 {
+		return nil, errors.New("tls server certFile and keyFile is must")
+	}
+	pool, err := this.certPool()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: certs, ClientCAs: pool}
+	if this.ClientAuth {
+		if pool == nil {
+			return nil, errors.New("tls client auth needs caFile")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func (this *TLSConfig) ClientConfig() (*tls.Config, error) {
+	certs, err := this.certificates()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := this.certPool()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       certs,
+		RootCAs:            pool,
+		ServerName:         this.ServerName,
+		InsecureSkipVerify: this.InsecureSkipVerify,
+	}, nil
+}
+
+//客户端连接时根据地址设置服务端名称
+func clientTLSConfig(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" {
+		return config
+	}
+	clone := config.Clone()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		clone.ServerName = host
+	} else {
+		clone.ServerName = address
+	}
+	return clone
+}
+
+//执行TLS握手，并把对端证书信息放入channel属性中
+func tlsHandshake(conn *tls.Conn, timeout time.Duration, attributes map[string]interface{}) error {
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 && attributes != nil {
+		attributes[TLS_PEER_CERTIFICATE] = certs[0]
+		attributes[TLS_PEER_SUBJECT] = certs[0].Subject.String()
+		attributes[TLS_PEER_COMMON_NAME] = certs[0].Subject.CommonName
+	}
+	return nil
+}
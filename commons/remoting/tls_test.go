@@ -0,0 +1,142 @@
+package remoting
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tlsTestHandler struct {
+	HandlerWrapper
+	received chan string
+}
+
+func (h *tlsTestHandler) OnMessage(c RemotingChannel, msg interface{}) {
+	cn, _ := c.Attributes()[TLS_PEER_COMMON_NAME].(string)
+	h.received <- cn + ":" + string(msg.([]byte))
+}
+
+func writePem(t *testing.T, path, typ string, bs []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: bs}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//生成 ca.pem, {name}.pem, {name}.key
+func makeCerts(t *testing.T, dir string, names ...string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tenured ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePem(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caBytes)
+	ca, _ := x509.ParseCertificate(caBytes)
+
+	for i, name := range names {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name, Organization: []string{"tenured"}},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyBytes, _ := x509.MarshalECPrivateKey(key)
+		writePem(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", certBytes)
+		writePem(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyBytes)
+	}
+}
+
+func TestRemoting_MutualTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tenured-tls")
+	defer os.RemoveAll(dir)
+	makeCerts(t, dir, "store", "console")
+
+	serverConfig := DefaultConfig()
+	serverConfig.TLS = &TLSConfig{
+		CertFile: filepath.Join(dir, "store.pem"), KeyFile: filepath.Join(dir, "store.key"),
+		CAFile: filepath.Join(dir, "ca.pem"), ClientAuth: true,
+	}
+	server, _ := NewRemotingServer("127.0.0.1:6081", serverConfig)
+	handler := &tlsTestHandler{received: make(chan string, 10)}
+	server.SetHandler(handler)
+	server.SetCoder(DefaultCoder())
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	clientConfig := DefaultConfig()
+	clientConfig.TLS = &TLSConfig{
+		CertFile: filepath.Join(dir, "console.pem"), KeyFile: filepath.Join(dir, "console.key"),
+		CAFile: filepath.Join(dir, "ca.pem"),
+	}
+	tlsClient := NewRemotingClient(clientConfig)
+	tlsClient.SetHandler(&HandlerWrapper{})
+	tlsClient.SetCoder(DefaultCoder())
+	assert.Nil(t, tlsClient.Start())
+	defer tlsClient.Shutdown(true)
+
+	err := tlsClient.SendTo("127.0.0.1:6081", []byte("hello"), time.Second*3)
+	assert.Nil(t, err)
+	select {
+	case msg := <-handler.received:
+		assert.Equal(t, "console:hello", msg)
+	case <-time.After(time.Second * 3):
+		t.Fatal("not received")
+	}
+
+	//没有客户端证书的连接将被拒绝
+	noCertConfig := DefaultConfig()
+	noCertConfig.TLS = &TLSConfig{CAFile: filepath.Join(dir, "ca.pem")}
+	noCertClient := NewRemotingClient(noCertConfig)
+	noCertClient.SetHandler(&HandlerWrapper{})
+	noCertClient.SetCoder(DefaultCoder())
+	assert.Nil(t, noCertClient.Start())
+	defer noCertClient.Shutdown(true)
+
+	_ = noCertClient.SendTo("127.0.0.1:6081", []byte("hello"), time.Second*3)
+	select {
+	case msg := <-handler.received:
+		t.Fatal("received message without client certificate: ", msg)
+	case <-time.After(time.Millisecond * 500):
+	}
+}
+
+//证书错误或者服务启动失败时Start返回错误
+func TestRemotingServer_StartError(t *testing.T) {
+	config := DefaultConfig()
+	config.TLS = &TLSConfig{CertFile: "not-exists.pem", KeyFile: "not-exists.key"}
+	server, _ := NewRemotingServer("127.0.0.1:6082", config)
+	server.SetHandler(&HandlerWrapper{})
+	server.SetCoder(DefaultCoder())
+	assert.NotNil(t, server.Start())
+
+	noCoder, _ := NewRemotingServer("127.0.0.1:6082", DefaultConfig())
+	noCoder.SetHandler(&HandlerWrapper{})
+	assert.NotNil(t, noCoder.Start())
+}
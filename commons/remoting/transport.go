@@ -0,0 +1,260 @@
+package remoting
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//传输层监听器
+type Listener interface {
+	Accept() (net.Conn, error)
+
+	//设置Accept超时时间
+	SetDeadline(t time.Time) error
+
+	Close() error
+
+	Addr() net.Addr
+}
+
+//传输层实现，RemotingServer和RemotingClient通过地址的scheme选择传输方式，
+//例如：tcp://127.0.0.1:6072, tcp6://[::1]:6072, unix:///run/tenured/store.sock, pipe://store, ws://127.0.0.1:6075/tenured
+type Transport interface {
+	Listen(address string) (Listener, error)
+
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+var transports = map[string]Transport{}
+
+func RegisterTransport(scheme string, transport Transport) {
+	transports[scheme] = transport
+}
+
+func GetTransport(scheme string) (Transport, bool) {
+	transport, has := transports[scheme]
+	return transport, has
+}
+
+//解析地址，格式：scheme://address，没有scheme时使用tcp
+func ParseAddress(address string) (scheme string, addr string) {
+	if idx := strings.Index(address, "://"); idx > 0 {
+		return address[:idx], address[idx+3:]
+	}
+	return "tcp", address
+}
+
+func transportOf(address string) (Transport, string, error) {
+	scheme, addr := ParseAddress(address)
+	if transport, has := GetTransport(scheme); has {
+		return transport, addr, nil
+	}
+	return nil, "", errors.New("not support transport: " + scheme)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+//tcp,tcp4,tcp6
+type tcpTransport struct {
+	network string
+}
+
+func (this *tcpTransport) Listen(address string) (Listener, error) {
+	if tcpAddr, err := net.ResolveTCPAddr(this.network, address); err != nil {
+		return nil, err
+	} else {
+		return net.ListenTCP(this.network, tcpAddr)
+	}
+}
+
+func (this *tcpTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(this.network, address, timeout)
+}
+
+//unix domain socket
+type unixTransport struct{}
+
+type unixListener struct {
+	*net.UnixListener
+	seq uint32
+}
+
+//unix socket 客户端地址为空，这里生成唯一地址用于区分channel
+func (this *unixListener) Accept() (net.Conn, error) {
+	conn, err := this.UnixListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	remote := fmt.Sprintf("%s#%d", this.Addr().String(), atomic.AddUint32(&this.seq, 1))
+	return &addrConn{Conn: conn, remote: &net.UnixAddr{Name: remote, Net: "unix"}}, nil
+}
+
+func (this *unixTransport) Listen(address string) (Listener, error) {
+	//删除上次未正常关闭遗留的socket文件
+	if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(address)
+	}
+	if unixAddr, err := net.ResolveUnixAddr("unix", address); err != nil {
+		return nil, err
+	} else if listener, err := net.ListenUnix("unix", unixAddr); err != nil {
+		return nil, err
+	} else {
+		return &unixListener{UnixListener: listener}, nil
+	}
+}
+
+func (this *unixTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", address, timeout)
+}
+
+type addrConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (this *addrConn) LocalAddr() net.Addr {
+	if this.local != nil {
+		return this.local
+	}
+	return this.Conn.LocalAddr()
+}
+
+func (this *addrConn) RemoteAddr() net.Addr {
+	if this.remote != nil {
+		return this.remote
+	}
+	return this.Conn.RemoteAddr()
+}
+
+//进程内内存管道，不占用端口，主要用于sidecar测试和单元测试
+type pipeTransport struct {
+	lock      sync.Mutex
+	listeners map[string]*chanListener
+}
+
+type pipeAddr string
+
+func (this pipeAddr) Network() string { return "pipe" }
+func (this pipeAddr) String() string  { return string(this) }
+
+//通过chan传递连接的监听器，用于pipe,websocket等非socket实现的传输层
+type chanListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closeChan chan struct{}
+	closeOnce sync.Once
+	onClose   func()
+	deadline  atomic.Value
+	seq       uint32
+}
+
+func newChanListener(addr net.Addr, onClose func()) *chanListener {
+	return &chanListener{
+		addr: addr, onClose: onClose,
+		conns: make(chan net.Conn), closeChan: make(chan struct{}),
+	}
+}
+
+func (this *chanListener) Accept() (net.Conn, error) {
+	var timeout <-chan time.Time
+	if deadline, ok := this.deadline.Load().(time.Time); ok && !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case conn := <-this.conns:
+		return conn, nil
+	case <-this.closeChan:
+		return nil, errors.New("use of closed listener")
+	case <-timeout:
+		return nil, &net.OpError{Op: "accept", Net: this.addr.Network(), Addr: this.addr, Err: timeoutError{}}
+	}
+}
+
+//把连接交给Accept，监听器关闭或者超时返回false
+func (this *chanListener) offer(conn net.Conn, timeout time.Duration) bool {
+	select {
+	case this.conns <- conn:
+		return true
+	case <-this.closeChan:
+	case <-time.After(timeout):
+	}
+	return false
+}
+
+func (this *chanListener) SetDeadline(t time.Time) error {
+	this.deadline.Store(t)
+	return nil
+}
+
+func (this *chanListener) Close() error {
+	this.closeOnce.Do(func() {
+		if this.onClose != nil {
+			this.onClose()
+		}
+		close(this.closeChan)
+	})
+	return nil
+}
+
+func (this *chanListener) Addr() net.Addr {
+	return this.addr
+}
+
+func (this *pipeTransport) Listen(address string) (Listener, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, has := this.listeners[address]; has {
+		return nil, errors.New("pipe address already in use: " + address)
+	}
+	listener := newChanListener(pipeAddr(address), func() {
+		this.lock.Lock()
+		delete(this.listeners, address)
+		this.lock.Unlock()
+	})
+	this.listeners[address] = listener
+	return listener, nil
+}
+
+func (this *pipeTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	this.lock.Lock()
+	listener, has := this.listeners[address]
+	this.lock.Unlock()
+	if !has {
+		return nil, pipeRefused(address)
+	}
+
+	client, server := net.Pipe()
+	clientAddr := pipeAddr(fmt.Sprintf("%s#%d", address, atomic.AddUint32(&listener.seq, 1)))
+	if listener.offer(&addrConn{Conn: server, local: listener.Addr(), remote: clientAddr}, timeout) {
+		return &addrConn{Conn: client, local: clientAddr, remote: listener.Addr()}, nil
+	}
+	_ = client.Close()
+	_ = server.Close()
+	return nil, pipeRefused(address)
+}
+
+//和tcp一样返回dial的OpError，调用方可以区分连接失败和请求失败
+func pipeRefused(address string) error {
+	return &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: errors.New("connection refused")}
+}
+
+func init() {
+	RegisterTransport("tcp", &tcpTransport{network: "tcp"})
+	RegisterTransport("tcp4", &tcpTransport{network: "tcp4"})
+	RegisterTransport("tcp6", &tcpTransport{network: "tcp6"})
+	RegisterTransport("unix", &unixTransport{})
+	RegisterTransport("pipe", &pipeTransport{listeners: map[string]*chanListener{}})
+}
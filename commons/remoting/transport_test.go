@@ -0,0 +1,165 @@
+package remoting
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type echoHandler struct {
+	HandlerWrapper
+	received chan string
+}
+
+func (h *echoHandler) OnMessage(c RemotingChannel, msg interface{}) {
+	h.received <- c.RemoteAddr() + ">" + string(msg.([]byte))
+}
+
+func TestParseAddress(t *testing.T) {
+	scheme, address := ParseAddress("127.0.0.1:6072")
+	assert.Equal(t, "tcp", scheme)
+	assert.Equal(t, "127.0.0.1:6072", address)
+
+	scheme, address = ParseAddress("unix:///run/tenured/store.sock")
+	assert.Equal(t, "unix", scheme)
+	assert.Equal(t, "/run/tenured/store.sock", address)
+
+	scheme, address = ParseAddress("ws://127.0.0.1:6075/tenured")
+	assert.Equal(t, "ws", scheme)
+	assert.Equal(t, "127.0.0.1:6075/tenured", address)
+
+	scheme, address = ParseAddress("tcp6://[::1]:6072")
+	assert.Equal(t, "tcp6", scheme)
+	assert.Equal(t, "[::1]:6072", address)
+}
+
+func testTransport(t *testing.T, address string) {
+	server, _ := NewRemotingServer(address, nil)
+	handler := &echoHandler{received: make(chan string, 10)}
+	server.SetHandler(handler)
+	server.SetCoder(DefaultCoder())
+	assert.Nil(t, server.Start())
+	defer server.Shutdown(true)
+
+	client := NewRemotingClient(nil)
+	client.SetHandler(&HandlerWrapper{})
+	client.SetCoder(DefaultCoder())
+	assert.Nil(t, client.Start())
+	defer client.Shutdown(true)
+
+	for i := 0; i < 2; i++ {
+		err := client.SendTo(address, []byte("hello"), time.Second)
+		assert.Nil(t, err)
+		select {
+		case msg := <-handler.received:
+			t.Log(msg)
+			assert.Equal(t, "hello", msg[len(msg)-5:])
+		case <-time.After(time.Second * 3):
+			t.Fatal("not received: ", address)
+		}
+	}
+}
+
+func TestTransport_Pipe(t *testing.T) {
+	testTransport(t, "pipe://store")
+
+	_, err := (&pipeTransport{listeners: map[string]*chanListener{}}).Dial("none", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestTransport_Unix(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tenured-unix")
+	defer os.RemoveAll(dir)
+	testTransport(t, "unix://"+filepath.Join(dir, "store.sock"))
+}
+
+func TestTransport_WebSocket(t *testing.T) {
+	testTransport(t, "ws://127.0.0.1:6083/tenured")
+}
+
+func TestTransport_WebSocketTLS(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tenured-wss")
+	defer os.RemoveAll(dir)
+	makeCerts(t, dir, "store")
+
+	_, err := wssTransport(t, nil).Listen("127.0.0.1:6084/tenured")
+	assert.NotNil(t, err)
+
+	RegisterTransport("wss", wssTransport(t, &TLSConfig{
+		CertFile: filepath.Join(dir, "store.pem"),
+		KeyFile:  filepath.Join(dir, "store.key"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}))
+	defer RegisterTransport("wss", wssTransport(t, nil))
+	testTransport(t, "wss://127.0.0.1:6084/tenured")
+}
+
+func wssTransport(t *testing.T, config *TLSConfig) Transport {
+	transport, err := NewWebsocketTransport(true, &WebsocketOptions{TLS: config})
+	assert.Nil(t, err)
+	return transport
+}
+
+func TestTransport_WebSocketOrigin(t *testing.T) {
+	transport, _ := NewWebsocketTransport(false, &WebsocketOptions{AllowedOrigins: []string{"https://console.tenured.io"}})
+	listener, err := transport.Listen("127.0.0.1:6085/tenured")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	dial := func(origin string) error {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:6085/tenured", header)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+	assert.Nil(t, dial(""))
+	assert.Nil(t, dial("https://console.tenured.io"))
+	assert.NotNil(t, dial("https://evil.example.com"))
+}
+
+//不再读取的连接关闭后，读协程退出
+func TestTransport_WebSocketCloseUnblocksReader(t *testing.T) {
+	transport, _ := NewWebsocketTransport(false, nil)
+	listener, err := transport.Listen("127.0.0.1:6086/tenured")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	client, err := transport.Dial("127.0.0.1:6086/tenured", time.Second)
+	assert.Nil(t, err)
+	defer client.Close()
+	server, err := listener.Accept()
+	assert.Nil(t, err)
+
+	//超过缓冲的消息数，读协程阻塞在发送上
+	for i := 0; i < 20; i++ {
+		_, err := client.Write([]byte("hello"))
+		assert.Nil(t, err)
+	}
+	wsConn := server.(*websocketConn)
+	for i := 0; i < 100 && len(wsConn.messages) < cap(wsConn.messages); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Nil(t, server.Close())
+
+	timeout := time.After(time.Second * 3)
+	for {
+		select {
+		case _, ok := <-wsConn.messages:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("websocket reader not exit")
+		}
+	}
+}
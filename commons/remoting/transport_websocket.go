@@ -0,0 +1,248 @@
+package remoting
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type WebsocketOptions struct {
+	//允许连接的浏览器来源，例如：https://console.tenured.io，"*"允许所有来源。
+	//为空时只允许同源的浏览器和不带Origin的非浏览器客户端
+	AllowedOrigins []string `json:"allowedOrigins,omitempty" yaml:"allowedOrigins,omitempty"`
+
+	//wss加密传输，服务端必须配置证书，客户端为空时使用系统证书校验服务端
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+//WebSocket传输，地址格式：ws://host:port/path，wss://host:port/path。
+//每次写出的数据作为一个binary消息发送，读取时把消息拼接为字节流交给RemotingCoder，
+//所以TenuredCommand等编码器不需要任何修改即可在浏览器和小程序中使用。
+type websocketTransport struct {
+	scheme   string
+	upgrader websocket.Upgrader
+
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+}
+
+//创建WebSocket传输，secure为true时使用wss，通过 RegisterTransport 替换默认的ws和wss传输
+func NewWebsocketTransport(secure bool, options *WebsocketOptions) (Transport, error) {
+	if options == nil {
+		options = &WebsocketOptions{}
+	}
+	transport := &websocketTransport{
+		scheme: "ws",
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			CheckOrigin:     checkOrigin(options.AllowedOrigins),
+		},
+	}
+	if secure {
+		transport.scheme = "wss"
+		transport.clientTLS = &tls.Config{}
+		if options.TLS != nil {
+			var err error
+			if transport.clientTLS, err = options.TLS.ClientConfig(); err != nil {
+				return nil, err
+			}
+			//没有证书时只能作为客户端使用
+			if options.TLS.CertFile != "" {
+				if transport.serverTLS, err = options.TLS.ServerConfig(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return transport, nil
+}
+
+//允许的来源，为空时使用websocket默认的同源检查
+func checkOrigin(allowedOrigins []string) func(r *http.Request) bool {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+//拆分 host:port/path
+func splitWebsocketAddress(address string) (string, string) {
+	if idx := strings.Index(address, "/"); idx >= 0 {
+		return address[:idx], address[idx:]
+	}
+	return address, "/"
+}
+
+func (this *websocketTransport) Listen(address string) (Listener, error) {
+	if this.scheme == "wss" && this.serverTLS == nil {
+		return nil, errors.New("wss server needs tls certFile and keyFile")
+	}
+	hostPort, path := splitWebsocketAddress(address)
+	tcpListener, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if this.serverTLS != nil {
+		tcpListener = tls.NewListener(tcpListener, this.serverTLS)
+	}
+
+	server := &http.Server{}
+	listener := newChanListener(tcpListener.Addr(), func() {
+		_ = server.Close()
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		conn, err := this.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Infof("websocket upgrade %s error: %s", r.RemoteAddr, err)
+			return
+		}
+		if !listener.offer(newWebsocketConn(conn), time.Second*3) {
+			_ = conn.Close()
+		}
+	})
+	server.Handler = mux
+
+	go func() {
+		if err := server.Serve(tcpListener); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("websocket server %s error: %s", address, err)
+		}
+		_ = listener.Close()
+	}()
+	return listener, nil
+}
+
+func (this *websocketTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	dialer := &websocket.Dialer{HandshakeTimeout: timeout}
+	if this.clientTLS != nil {
+		hostPort, _ := splitWebsocketAddress(address)
+		dialer.TLSClientConfig = clientTLSConfig(this.clientTLS, hostPort)
+	}
+	if conn, _, err := dialer.Dial(this.scheme+"://"+address, nil); err != nil {
+		return nil, err
+	} else {
+		return newWebsocketConn(conn), nil
+	}
+}
+
+//websocket.Conn 读超时后连接将不可用，而channel读取时会定时设置读超时，
+//所以这里由单独的协程读取消息，Read通过 readDeadline 模拟超时
+type websocketConn struct {
+	*websocket.Conn
+
+	messages chan []byte
+	readErr  error
+	buffer   []byte
+
+	//连接关闭后读协程不再向messages发送，避免Read不再读取时协程泄露
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readDeadline atomic.Value
+	writeLock    sync.Mutex
+}
+
+func newWebsocketConn(conn *websocket.Conn) *websocketConn {
+	wsConn := &websocketConn{Conn: conn, messages: make(chan []byte, 16), closed: make(chan struct{})}
+	go wsConn.readMessages()
+	return wsConn
+}
+
+func (this *websocketConn) readMessages() {
+	defer close(this.messages)
+	for {
+		messageType, data, err := this.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				err = io.EOF
+			}
+			this.readErr = err
+			return
+		}
+		if messageType == websocket.BinaryMessage && len(data) > 0 {
+			select {
+			case this.messages <- data:
+			case <-this.closed:
+				this.readErr = io.EOF
+				return
+			}
+		}
+	}
+}
+
+func (this *websocketConn) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+	})
+	return this.Conn.Close()
+}
+
+func (this *websocketConn) Read(p []byte) (int, error) {
+	if len(this.buffer) == 0 {
+		var timeout <-chan time.Time
+		if deadline, ok := this.readDeadline.Load().(time.Time); ok && !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case data, ok := <-this.messages:
+			if !ok {
+				return 0, this.readErr
+			}
+			this.buffer = data
+		case <-timeout:
+			return 0, &net.OpError{Op: "read", Net: "ws", Addr: this.RemoteAddr(), Err: timeoutError{}}
+		}
+	}
+	n := copy(p, this.buffer)
+	this.buffer = this.buffer[n:]
+	return n, nil
+}
+
+func (this *websocketConn) Write(p []byte) (int, error) {
+	this.writeLock.Lock()
+	defer this.writeLock.Unlock()
+	if err := this.Conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (this *websocketConn) SetReadDeadline(t time.Time) error {
+	this.readDeadline.Store(t)
+	return nil
+}
+
+func (this *websocketConn) SetDeadline(t time.Time) error {
+	_ = this.SetReadDeadline(t)
+	return this.Conn.SetWriteDeadline(t)
+}
+
+func init() {
+	//认证在tenured协议层完成，默认只检查浏览器来源，需要跨域或者wss服务端时重新注册
+	ws, _ := NewWebsocketTransport(false, nil)
+	RegisterTransport("ws", ws)
+	wss, _ := NewWebsocketTransport(true, nil)
+	RegisterTransport("wss", wss)
+}
@@ -4,6 +4,8 @@ go 1.12
 
 require (
 	github.com/emirpasic/gods v1.12.0
+	github.com/gorilla/websocket v1.4.1
+	github.com/hashicorp/go-msgpack v0.5.5
 	github.com/hashicorp/consul v1.4.3
 	github.com/hashicorp/go-cleanhttp v0.5.0 // indirect
 	github.com/hashicorp/go-rootcerts v1.0.0 // indirect
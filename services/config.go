@@ -6,7 +6,9 @@ import (
 	"github.com/go-yaml/yaml"
 	"github.com/ihaiker/tenured-go-server/commons"
 	_ "github.com/ihaiker/tenured-go-server/commons/logs"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
 	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/commons/runtime"
 	"github.com/sirupsen/logrus"
@@ -31,6 +33,48 @@ type Tcp struct {
 	*remoting.RemotingConfig
 
 	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+
+	//抓包文件，不为空时记录收发的消息，用于tenured replay回放
+	Capture string `json:"capture,omitempty" yaml:"capture,omitempty"`
+}
+
+//服务默认的通信配置，header优先使用msgpack编码
+func NewRemotingConfig() *remoting.RemotingConfig {
+	config := remoting.DefaultConfig()
+	config.HeaderCodec = protocol.DEFAULT_HEADER_CODECS
+	return config
+}
+
+//集群内模块认证
+type Cluster struct {
+	//集群密钥，集群内所有模块保持一致，为空时不校验模块签名
+	Secret string `json:"secret" yaml:"secret"`
+
+	//模块名称，为空时使用 前缀_服务名称
+	Module string `json:"module,omitempty" yaml:"module,omitempty"`
+
+	//允许连接的模块，为空时允许所有签名正确的模块
+	AllowModules []string `json:"allowModules,omitempty" yaml:"allowModules,omitempty"`
+}
+
+func (this *Cluster) ModuleName(prefix, serverName string) string {
+	if this != nil && this.Module != "" {
+		return this.Module
+	}
+	return prefix + "_" + serverName
+}
+
+//调用集群内服务的客户端配置，使用模块名称和集群密钥签名认证
+func (this *Cluster) ClientConfig(prefix, serverName string, remotingConfig *remoting.RemotingConfig) *protocol.ClientConfig {
+	config := &protocol.ClientConfig{Module: this.ModuleName(prefix, serverName), Remoting: remotingConfig}
+	if this != nil {
+		config.Secret = this.Secret
+	}
+	return config
+}
+
+func NewCluster() *Cluster {
+	return &Cluster{Secret: mixins.Get(mixins.KeyClusterSecret, "")}
 }
 
 type ExecutorParam struct {
@@ -3,7 +3,6 @@ package console
 import (
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
-	"github.com/ihaiker/tenured-go-server/commons/remoting"
 	"github.com/ihaiker/tenured-go-server/services"
 )
 
@@ -18,6 +17,8 @@ type ConsoleConfig struct {
 
 	Registry *services.Registry `json:"registry" yaml:"registry"` //注册中心
 
+	Cluster *services.Cluster `json:"cluster" yaml:"cluster"` //集群内模块认证
+
 	Tcp *services.Tcp `json:"tcp" yaml:"tcp"`
 
 	Executors services.Executors `json:"executors"`
@@ -39,11 +40,12 @@ func NewConsoleConfig() *ConsoleConfig {
 				"CheckType": "http",
 			},
 		},
+		Cluster: services.NewCluster(),
 		Tcp: &services.Tcp{
 			IpAndPort: &nets.IpAndPort{
 				Port: mixins.GetInt("tenured.console.port", 6073),
 			},
-			RemotingConfig: remoting.DefaultConfig(),
+			RemotingConfig: services.NewRemotingConfig(),
 		},
 		Executors: services.Executors(map[string]string{}),
 	}
@@ -1,9 +1,31 @@
 package ctl
 
-import "github.com/kataras/iris/context"
+import (
+	"github.com/ihaiker/tenured-go-server/api"
+	"github.com/kataras/iris"
+	"github.com/kataras/iris/context"
+)
 
-func applyAccount(ctx context.Context) {
+//store的账户服务，使用控制台的模块名称和集群密钥签名认证
+var accountService api.AccountService
+
+func SetAccountService(service api.AccountService) {
+	accountService = service
+}
 
+func applyAccount(ctx context.Context) {
+	account := &api.Account{}
+	if err := ctx.ReadJSON(account); err != nil {
+		ctx.StatusCode(iris.StatusBadRequest)
+		_, _ = ctx.JSON(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if err := accountService.Apply(account); err != nil {
+		ctx.StatusCode(iris.StatusInternalServerError)
+		_, _ = ctx.JSON(map[string]interface{}{"code": err.Code(), "error": err.Error()})
+		return
+	}
+	_, _ = ctx.JSON(account)
 }
 
 func init() {
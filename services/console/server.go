@@ -1,15 +1,57 @@
 package console
 
 import (
+	"errors"
+	"github.com/ihaiker/tenured-go-server/api/client"
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/registry"
+	"github.com/ihaiker/tenured-go-server/commons/registry/cache"
+	_ "github.com/ihaiker/tenured-go-server/commons/registry/consul"
 	"github.com/ihaiker/tenured-go-server/services/console/controller"
 )
 
 type ConsoleServer struct {
 	config     *ConsoleConfig
 	httpServer *ctl.HttpServer
+
+	registry      registry.ServiceRegistry
+	accountClient *client.AccountServiceClient
+}
+
+func (this *ConsoleServer) startRegistry() error {
+	pluginsConfig, err := registry.ParseConfig(this.config.Registry.Address)
+	if err != nil {
+		return err
+	}
+	plugins, has := registry.GetPlugins(pluginsConfig.Plugin)
+	if !has {
+		return errors.New("not found registry: " + this.config.Registry.Address)
+	}
+	if reg, err := plugins.Registry(*pluginsConfig); err != nil {
+		return err
+	} else {
+		this.registry = cache.NewCacheRegistry(reg)
+	}
+	return nil
+}
+
+//申请账户接口通过此客户端调用store，使用控制台的模块名称和集群密钥签名认证
+func (this *ConsoleServer) startAccountClient() (err error) {
+	clientConfig := this.config.Cluster.ClientConfig(this.config.Prefix, "console", this.config.Tcp.RemotingConfig)
+	if this.accountClient, err = client.NewAccountServiceClient(this.config.Prefix+"_store", this.registry, clientConfig); err != nil {
+		return
+	}
+	ctl.SetAccountService(this.accountClient)
+	return this.accountClient.Start()
 }
 
 func (this *ConsoleServer) Start() error {
+	if err := this.startRegistry(); err != nil {
+		return err
+	}
+	if err := this.startAccountClient(); err != nil {
+		return err
+	}
 	logger.Info("start console http server")
 	return this.httpServer.Start()
 }
@@ -17,6 +59,10 @@ func (this *ConsoleServer) Start() error {
 func (this *ConsoleServer) Shutdown(interrupt bool) {
 	logger.Info("stop console http server")
 	this.httpServer.Shutdown(interrupt)
+	if this.accountClient != nil {
+		this.accountClient.Shutdown(interrupt)
+	}
+	commons.ShutdownIfService(this.registry, interrupt)
 }
 
 func newConsoleServer(config *ConsoleConfig) (*ConsoleServer, error) {
@@ -47,7 +47,7 @@ func Server(reg registry.RegistryPlugins) (commons.Service, error) {
 		}
 		header["tenured"] = time.Now().Format("2006-01-02")
 
-		ack := protocol.NewACK(command.ID())
+		ack := protocol.NewResponse(command)
 		if err := ack.SetHeader(header); err != nil {
 			logrus.
 				WithField("ip", channel.RemoteAddr()).
@@ -3,7 +3,7 @@ package store
 import (
 	"github.com/ihaiker/tenured-go-server/commons/mixins"
 	"github.com/ihaiker/tenured-go-server/commons/nets"
-	"github.com/ihaiker/tenured-go-server/commons/remoting"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
 	"github.com/ihaiker/tenured-go-server/services"
 )
 
@@ -16,11 +16,25 @@ type storeConfig struct {
 
 	Registry *services.Registry `json:"registry" yaml:"registry"` //注册中心
 
+	Cluster *services.Cluster `json:"cluster" yaml:"cluster"` //集群内模块认证
+
+	Authorization *protocol.AuthorizationConfig `json:"authorization" yaml:"authorization"` //请求授权，为空并且配置了集群密钥时使用默认授权
+
 	Tcp *services.Tcp `json:"tcp" yaml:"tcp"`
 
 	Executors services.Executors `json:"executors"`
 }
 
+//默认授权，账户申请只允许控制台调用。模块名称只有通过集群密钥签名校验后才可信，所以只在配置了集群密钥时使用
+func defaultAuthorization(prefix string) *protocol.AuthorizationConfig {
+	return &protocol.AuthorizationConfig{
+		DefaultAllow: true,
+		Policies: []*protocol.AuthorizationPolicy{
+			{Command: "AccountService.Apply", Modules: []string{prefix + "_console"}},
+		},
+	}
+}
+
 func NewStoreConfig() *storeConfig {
 	return &storeConfig{
 		Prefix: mixins.Get(mixins.KeyServerPrefix, mixins.ServerPrefix),
@@ -33,11 +47,12 @@ func NewStoreConfig() *storeConfig {
 		Registry: &services.Registry{
 			Address: mixins.Get(mixins.KeyRegistry, mixins.Registry),
 		},
+		Cluster: services.NewCluster(),
 		Tcp: &services.Tcp{
 			IpAndPort: &nets.IpAndPort{
 				Port: mixins.GetInt("tenured.store.port", 6072),
 			},
-			RemotingConfig: remoting.DefaultConfig(),
+			RemotingConfig: services.NewRemotingConfig(),
 		},
 		Executors: services.Executors(map[string]string{}),
 	}
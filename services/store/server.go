@@ -56,10 +56,18 @@ func (this *storeServer) startTenuredServer() (err error) {
 	}
 
 	this.server.AuthHeader = &protocol.AuthHeader{
-		Module:     fmt.Sprintf("%s_%s", this.config.Prefix, "store"),
+		Module:     this.config.Cluster.ModuleName(this.config.Prefix, "store"),
 		Address:    this.address,
 		Attributes: this.config.Tcp.Attributes,
 	}
+	if err = this.initClusterAuth(); err != nil {
+		return err
+	}
+	if this.config.Tcp.Capture != "" {
+		if err = this.server.StartCapture(this.config.Tcp.Capture); err != nil {
+			return err
+		}
+	}
 
 	if err = this.server.Start(); err != nil {
 		return
@@ -73,6 +81,31 @@ func (this *storeServer) startTenuredServer() (err error) {
 	return
 }
 
+//校验集群内模块的签名，并按照配置授权请求
+func (this *storeServer) initClusterAuth() error {
+	authorizationConfig := this.config.Authorization
+	if this.config.Cluster == nil || this.config.Cluster.Secret == "" {
+		logger.Warn("cluster secret is empty, any module can connect to store")
+	} else {
+		this.server.AuthChecker = &protocol.ClusterAuthChecker{
+			Secret:       this.config.Cluster.Secret,
+			AllowModules: this.config.Cluster.AllowModules,
+		}
+		if authorizationConfig == nil {
+			authorizationConfig = defaultAuthorization(this.config.Prefix)
+		}
+	}
+	if authorizationConfig == nil {
+		return nil
+	}
+	authorization, err := protocol.NewAuthorization(authorizationConfig)
+	if err != nil {
+		return err
+	}
+	this.server.Use(authorization.Interceptor())
+	return nil
+}
+
 func (this *storeServer) maxMachineId(serverName string) (uint16, error) {
 	if ss, err := this.registry.Lookup(serverName, nil); err != nil {
 		return 0, err
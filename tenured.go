@@ -30,6 +30,8 @@ func init() {
 	rootCmd.AddCommand(console.ConsoleCommand)
 	rootCmd.AddCommand(tools.ConfigCmd)
 	rootCmd.AddCommand(tools.InstallCommand)
+	rootCmd.AddCommand(tools.ReplayCommand)
+	rootCmd.AddCommand(tools.ProxyCommand)
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "debug module")
 }
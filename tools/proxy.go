@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
+	"github.com/ihaiker/tenured-go-server/commons/runtime/signal"
+	"github.com/spf13/cobra"
+)
+
+var ProxyCommand = &cobra.Command{
+	Use:   "proxy",
+	Short: "Forward tenured traffic to an upstream server and print every decoded frame",
+	Example: `	tenured proxy -l 127.0.0.1:7072 -u 127.0.0.1:6072 #print all frames
+	tenured proxy -l 127.0.0.1:7072 -u 127.0.0.1:6072 -c AccountService.Get --latency 200ms #delay AccountService.Get
+	tenured proxy -l 127.0.0.1:7072 -u 127.0.0.1:6072 -c 2001 --error-rate 0.5 #half of 2001 requests return error`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = commons.Catch(e)
+			}
+		}()
+		listen, err := cmd.PersistentFlags().GetString("listen")
+		commons.Painc(err)
+		upstream, err := cmd.PersistentFlags().GetString("upstream")
+		commons.Painc(err)
+		if listen == "" || upstream == "" {
+			return cmd.Usage()
+		}
+
+		options := protocol.DefaultProxyOptions()
+		commands, err := cmd.PersistentFlags().GetString("code")
+		commons.Painc(err)
+		options.Codes, err = protocol.ParseCommands(commands)
+		commons.Painc(err)
+		options.Latency, err = cmd.PersistentFlags().GetDuration("latency")
+		commons.Painc(err)
+		options.ErrorRate, err = cmd.PersistentFlags().GetFloat64("error-rate")
+		commons.Painc(err)
+		options.ErrorCode, err = cmd.PersistentFlags().GetString("error-code")
+		commons.Painc(err)
+		options.BodyPreview, err = cmd.PersistentFlags().GetInt("body")
+		commons.Painc(err)
+
+		proxy := protocol.NewTenuredProxy(listen, upstream, options)
+		commons.Painc(proxy.Start())
+		defer proxy.Shutdown(true)
+
+		signal.Signal(func() {})
+		return nil
+	},
+}
+
+func init() {
+	ProxyCommand.PersistentFlags().StringP("listen", "l", "", "the local address to listen")
+	ProxyCommand.PersistentFlags().StringP("upstream", "u", "", "the upstream tenured server address")
+	ProxyCommand.PersistentFlags().StringP("code", "c", "", "only print and inject these request codes or names, comma separated")
+	ProxyCommand.PersistentFlags().Duration("latency", 0, "delay the requests before forwarding")
+	ProxyCommand.PersistentFlags().Float64("error-rate", 0, "the ratio of requests replied with an error instead of forwarding, 0-1")
+	ProxyCommand.PersistentFlags().String("error-code", "9999", "the error code of injected errors")
+	ProxyCommand.PersistentFlags().Int("body", 64, "the max body bytes to print")
+}
@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ihaiker/tenured-go-server/commons"
+	"github.com/ihaiker/tenured-go-server/commons/mixins"
+	"github.com/ihaiker/tenured-go-server/commons/protocol"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var ReplayCommand = &cobra.Command{
+	Use:   "replay",
+	Short: "Re-send captured tenured traffic to a server and report response mismatches",
+	Example: `	tenured replay -f store.capture -t 127.0.0.1:6072 #replay at original speed
+	tenured replay -f store.capture -t 127.0.0.1:6072 -x 0 #replay as fast as possible`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		defer func() {
+			if e := recover(); e != nil {
+				err = commons.Catch(e)
+			}
+		}()
+		file, err := cmd.PersistentFlags().GetString("file")
+		commons.Painc(err)
+		target, err := cmd.PersistentFlags().GetString("target")
+		commons.Painc(err)
+		if file == "" || target == "" {
+			return cmd.Usage()
+		}
+		speed, err := cmd.PersistentFlags().GetFloat64("speed")
+		commons.Painc(err)
+		timeout, err := cmd.PersistentFlags().GetDuration("timeout")
+		commons.Painc(err)
+		module, err := cmd.PersistentFlags().GetString("module")
+		commons.Painc(err)
+		secret, err := cmd.PersistentFlags().GetString("secret")
+		commons.Painc(err)
+
+		fs, err := os.Open(file)
+		commons.Painc(err)
+		records, err := protocol.ReadCapture(fs)
+		_ = fs.Close()
+		commons.Painc(err)
+
+		client, err := protocol.NewTenuredClient(nil)
+		commons.Painc(err)
+		client.AuthHeader = &protocol.AuthHeader{Module: module, Attributes: map[string]string{}}
+		client.Secret = secret
+		commons.Painc(client.Start())
+		defer client.Shutdown(true)
+
+		report := protocol.Replay(client, target, records, &protocol.ReplayOptions{Speed: speed, Timeout: timeout})
+		for _, mismatch := range report.Mismatches {
+			fmt.Println(mismatch.String())
+		}
+		fmt.Printf("requests: %d, matched: %d, unverified: %d, mismatched: %d\n",
+			report.Requests, report.Matched, report.Unverified, len(report.Mismatches))
+		if len(report.Mismatches) > 0 {
+			return errors.New("replay mismatched")
+		}
+		return nil
+	},
+}
+
+func init() {
+	ReplayCommand.PersistentFlags().StringP("file", "f", "", "the capture file")
+	ReplayCommand.PersistentFlags().StringP("target", "t", "", "the target tenured server address")
+	ReplayCommand.PersistentFlags().Float64P("speed", "x", 1, "replay speed, 1 is the original speed, 0 sends as fast as possible")
+	ReplayCommand.PersistentFlags().Duration("timeout", 3*time.Second, "the response timeout of each request")
+	ReplayCommand.PersistentFlags().StringP("module", "m", "tenured_replay", "the module name used to auth")
+	ReplayCommand.PersistentFlags().StringP("secret", "s", mixins.Get(mixins.KeyClusterSecret, ""), "the cluster secret")
+}